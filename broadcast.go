@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Broadcaster streams everything written to it to browsers over WebSockets,
+// as an asciicast v2 live stream: a header followed by events. Clients
+// joining late start with a snapshot of the screen. Slow clients are
+// disconnected rather than holding up the presentation.
+type Broadcaster struct {
+	mu      sync.Mutex
+	screen  *Screen
+	rows    int
+	cols    int
+	start   time.Time
+	partial []byte
+	clients map[*wsClient]struct{}
+}
+
+type wsClient struct {
+	conn net.Conn
+	out  chan wsMessage
+}
+
+type wsMessage struct {
+	op   byte
+	data []byte
+}
+
+// wsQueue is the number of messages a client may fall behind.
+const wsQueue = 1024
+
+func NewBroadcaster(rows, cols int) *Broadcaster {
+	screen := NewScreen(rows, cols)
+
+	return &Broadcaster{
+		screen:  screen,
+		rows:    screen.rows,
+		cols:    screen.cols,
+		start:   time.Now(),
+		clients: map[*wsClient]struct{}{},
+	}
+}
+
+func (b *Broadcaster) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.screen.Write(p)
+
+	var data []byte
+	data, b.partial = splitUTF8(b.partial, p)
+	if len(data) > 0 {
+		b.send(b.event("o", string(data)))
+	}
+
+	return len(p), nil
+}
+
+// Resize tells the clients that the terminal changed its size.
+func (b *Broadcaster) Resize(rows, cols int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.rows, b.cols = rows, cols
+	b.screen.Resize(rows, cols)
+	b.send(b.event("r", fmt.Sprintf("%dx%d", cols, rows)))
+}
+
+func (b *Broadcaster) event(kind, data string) []byte {
+	msg, _ := json.Marshal(Event{time.Since(b.start).Seconds(), kind, data})
+	return msg
+}
+
+// send queues msg for all clients. It must be called with the lock held.
+func (b *Broadcaster) send(msg []byte) {
+	for c := range b.clients {
+		select {
+		case c.out <- wsMessage{wsText, msg}:
+		default:
+			b.drop(c)
+		}
+	}
+}
+
+// drop disconnects c. It must be called with the lock held.
+func (b *Broadcaster) drop(c *wsClient) {
+	if _, ok := b.clients[c]; ok {
+		delete(b.clients, c)
+		close(c.out)
+	}
+}
+
+func (b *Broadcaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(viewerPage))
+	case "/ws":
+		b.serveWS(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (b *Broadcaster) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, br, err := wsUpgrade(w, r)
+	if err != nil {
+		return
+	}
+
+	c := &wsClient{conn: conn, out: make(chan wsMessage, wsQueue)}
+
+	b.mu.Lock()
+	header, _ := json.Marshal(map[string]any{
+		"version":   2,
+		"width":     b.cols,
+		"height":    b.rows,
+		"timestamp": b.start.Unix(),
+	})
+	c.out <- wsMessage{wsText, header}
+	c.out <- wsMessage{wsText, b.event("o", string(b.screen.Dump()))}
+	b.clients[c] = struct{}{}
+	b.mu.Unlock()
+
+	go c.writeLoop()
+	b.readLoop(c, br)
+}
+
+func (c *wsClient) writeLoop() {
+	defer c.conn.Close()
+
+	w := bufio.NewWriter(c.conn)
+	for msg := range c.out {
+		err := wsWriteFrame(w, msg.op, msg.data, nil)
+		if err == nil && len(c.out) == 0 {
+			err = w.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+	w.Flush()
+}
+
+// readLoop discards everything clients send until they go away.
+func (b *Broadcaster) readLoop(c *wsClient, br *bufio.Reader) {
+	defer func() {
+		b.mu.Lock()
+		b.drop(c)
+		b.mu.Unlock()
+	}()
+
+	for {
+		op, payload, err := wsReadFrame(br)
+		if err != nil {
+			return
+		}
+
+		switch op {
+		case wsClose:
+			b.mu.Lock()
+			if _, ok := b.clients[c]; ok {
+				select {
+				case c.out <- wsMessage{wsClose, payload}:
+				default:
+				}
+			}
+			b.mu.Unlock()
+			return
+		case wsPing:
+			b.mu.Lock()
+			if _, ok := b.clients[c]; ok {
+				select {
+				case c.out <- wsMessage{wsPong, payload}:
+				default:
+				}
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// viewerPage is a small terminal emulator which follows the live stream.
+const viewerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>term-present</title>
+<style>
+body { background: #000; color: #ccc; margin: 0; display: flex; justify-content: center; }
+pre { font: 16px/1.2 monospace; margin: 1em; }
+#status { position: fixed; top: .5em; right: 1em; color: #888; font: 12px sans-serif; }
+.b { font-weight: bold; }
+.cursor { background: #ccc; color: #000; }
+</style>
+</head>
+<body>
+<pre id="screen"></pre>
+<div id="status">connecting</div>
+<script>
+var colors = ["#000", "#c33", "#3c3", "#cc3", "#36c", "#c3c", "#3cc", "#ccc",
+  "#666", "#f66", "#6f6", "#ff6", "#69f", "#f6f", "#6ff", "#fff"];
+
+function Term(cols, rows) {
+  this.resize(cols, rows);
+  this.reset();
+}
+
+Term.prototype.blank = function () {
+  var line = [];
+  for (var i = 0; i < this.cols; i++) line.push({c: " ", a: this.attr});
+  return line;
+};
+
+Term.prototype.reset = function () {
+  this.attr = {};
+  this.cells = [];
+  for (var i = 0; i < this.rows; i++) this.cells.push(this.blank());
+  this.x = this.y = this.sx = this.sy = 0;
+  this.top = 0; this.bottom = this.rows - 1;
+  this.wrap = false; this.state = 0; this.params = ""; this.saved = null; this.hidden = false;
+};
+
+Term.prototype.resize = function (cols, rows) {
+  var old = this.cells || [];
+  this.cols = cols; this.rows = rows; this.attr = this.attr || {};
+  var skip = Math.max((this.y || 0) + 1 - rows, 0);
+  this.cells = [];
+  for (var i = 0; i < rows; i++) {
+    var line = this.blank(), src = old[i + skip] || [];
+    for (var j = 0; j < cols && j < src.length; j++) line[j] = src[j];
+    this.cells.push(line);
+  }
+  this.y = Math.max((this.y || 0) - skip, 0);
+  this.x = Math.min(this.x || 0, cols - 1);
+  this.top = 0; this.bottom = rows - 1;
+};
+
+Term.prototype.scroll = function (n, up) {
+  for (; n > 0; n--) {
+    if (up) {
+      this.cells.splice(this.top, 1);
+      this.cells.splice(this.bottom, 0, this.blank());
+    } else {
+      this.cells.splice(this.bottom, 1);
+      this.cells.splice(this.top, 0, this.blank());
+    }
+  }
+};
+
+Term.prototype.lf = function () {
+  this.wrap = false;
+  if (this.y == this.bottom) this.scroll(1, true);
+  else if (this.y < this.rows - 1) this.y++;
+};
+
+Term.prototype.put = function (c) {
+  if (this.wrap) { this.x = 0; this.lf(); }
+  this.cells[this.y][this.x] = {c: c, a: this.attr};
+  if (this.x == this.cols - 1) this.wrap = true; else this.x++;
+};
+
+Term.prototype.clear = function (y0, x0, y1, x1) {
+  for (var y = y0; y <= y1; y++)
+    for (var x = (y == y0 ? x0 : 0); x <= (y == y1 ? x1 : this.cols - 1) && x < this.cols; x++)
+      this.cells[y][x] = {c: " ", a: this.attr};
+};
+
+Term.prototype.sgr = function (args) {
+  if (args.length == 0) args = [0];
+  var a = Object.assign({}, this.attr);
+  for (var i = 0; i < args.length; i++) {
+    var n = args[i];
+    if (n == 0) a = {};
+    else if (n == 1) a.bold = true;
+    else if (n == 22) a.bold = false;
+    else if (n == 7) a.inv = true;
+    else if (n == 27) a.inv = false;
+    else if (n >= 30 && n <= 37) a.fg = n - 30;
+    else if (n >= 90 && n <= 97) a.fg = n - 82;
+    else if (n == 39) delete a.fg;
+    else if (n >= 40 && n <= 47) a.bg = n - 40;
+    else if (n >= 100 && n <= 107) a.bg = n - 92;
+    else if (n == 49) delete a.bg;
+    else if ((n == 38 || n == 48) && args[i + 1] == 5) {
+      if (args[i + 2] < 16) a[n == 38 ? "fg" : "bg"] = args[i + 2];
+      i += 2;
+    } else if ((n == 38 || n == 48) && args[i + 1] == 2) i += 4;
+  }
+  this.attr = a;
+};
+
+Term.prototype.csi = function (f) {
+  var raw = this.params, priv = raw[0] == "?";
+  var params = raw.replace(/^[?>=]/, ""), args = params == "" ? [] : params.split(";").map(Number);
+  var arg = function (i, d) { return args[i] || d; };
+  var clamp = function (v, lo, hi) { return Math.max(lo, Math.min(v, hi)); };
+  this.wrap = false;
+  switch (f) {
+  case "A": this.y = Math.max(this.y - arg(0, 1), 0); break;
+  case "B": this.y = Math.min(this.y + arg(0, 1), this.rows - 1); break;
+  case "C": this.x = Math.min(this.x + arg(0, 1), this.cols - 1); break;
+  case "D": this.x = Math.max(this.x - arg(0, 1), 0); break;
+  case "E": this.x = 0; this.y = Math.min(this.y + arg(0, 1), this.rows - 1); break;
+  case "F": this.x = 0; this.y = Math.max(this.y - arg(0, 1), 0); break;
+  case "G": case "` + "`" + `": this.x = clamp(arg(0, 1) - 1, 0, this.cols - 1); break;
+  case "d": this.y = clamp(arg(0, 1) - 1, 0, this.rows - 1); break;
+  case "H": case "f":
+    this.y = clamp(arg(0, 1) - 1, 0, this.rows - 1);
+    this.x = clamp(arg(1, 1) - 1, 0, this.cols - 1);
+    break;
+  case "J":
+    if (arg(0, 0) == 0) this.clear(this.y, this.x, this.rows - 1, this.cols - 1);
+    else if (arg(0, 0) == 1) this.clear(0, 0, this.y, this.x);
+    else this.clear(0, 0, this.rows - 1, this.cols - 1);
+    break;
+  case "K":
+    if (arg(0, 0) == 0) this.clear(this.y, this.x, this.y, this.cols - 1);
+    else if (arg(0, 0) == 1) this.clear(this.y, 0, this.y, this.x);
+    else this.clear(this.y, 0, this.y, this.cols - 1);
+    break;
+  case "X": this.clear(this.y, this.x, this.y, Math.min(this.x + arg(0, 1), this.cols) - 1); break;
+  case "P":
+    var n = Math.min(arg(0, 1), this.cols - this.x), line = this.cells[this.y];
+    line.splice(this.x, n);
+    for (; n > 0; n--) line.push({c: " ", a: this.attr});
+    break;
+  case "@":
+    var n = Math.min(arg(0, 1), this.cols - this.x), line = this.cells[this.y];
+    for (var i = 0; i < n; i++) line.splice(this.x, 0, {c: " ", a: this.attr});
+    line.length = this.cols;
+    break;
+  case "L": case "M":
+    if (this.y >= this.top && this.y <= this.bottom) {
+      var top = this.top;
+      this.top = this.y;
+      this.scroll(arg(0, 1), f == "M");
+      this.top = top;
+    }
+    break;
+  case "S": this.scroll(arg(0, 1), true); break;
+  case "T": this.scroll(arg(0, 1), false); break;
+  case "r":
+    var t = arg(0, 1) - 1, b = arg(1, this.rows) - 1;
+    if (t < b && b < this.rows) { this.top = t; this.bottom = b; this.x = this.y = 0; }
+    break;
+  case "s": this.sx = this.x; this.sy = this.y; break;
+  case "u": this.x = this.sx; this.y = this.sy; break;
+  case "m": this.sgr(args); break;
+  case "h": case "l":
+    if (!priv) break;
+    for (var i = 0; i < args.length; i++) {
+      var on = f == "h";
+      if (args[i] == 25) this.hidden = !on;
+      if (args[i] == 47 || args[i] == 1047 || args[i] == 1049) {
+        if (on && !this.saved) {
+          this.saved = {cells: this.cells, x: this.x, y: this.y};
+          this.cells = [];
+          for (var j = 0; j < this.rows; j++) this.cells.push(this.blank());
+        } else if (!on && this.saved) {
+          this.cells = this.saved.cells;
+          if (args[i] == 1049) { this.x = this.saved.x; this.y = this.saved.y; }
+          this.saved = null;
+        }
+      }
+    }
+    break;
+  }
+};
+
+Term.prototype.write = function (s) {
+  for (var k = 0; k < s.length; k++) {
+    var c = s[k], code = s.charCodeAt(k);
+    if (code >= 0xD800 && code < 0xDC00) { c = s.substr(k, 2); k++; }
+    switch (this.state) {
+    case 0:
+      if (c == "\x1b") this.state = 1;
+      else if (c == "\r") { this.x = 0; this.wrap = false; }
+      else if (c == "\n" || c == "\x0b" || c == "\x0c") this.lf();
+      else if (c == "\b") { if (this.x > 0) this.x--; this.wrap = false; }
+      else if (c == "\t") this.x = Math.min((Math.floor(this.x / 8) + 1) * 8, this.cols - 1);
+      else if (code >= 0x20 && code != 0x7f) this.put(c);
+      break;
+    case 1:
+      this.state = 0;
+      if (c == "[") { this.state = 2; this.params = ""; }
+      else if (c == "]") this.state = 3;
+      else if ("()*+#".indexOf(c) >= 0) this.state = 5;
+      else if (c == "7") { this.sx = this.x; this.sy = this.y; }
+      else if (c == "8") { this.x = this.sx; this.y = this.sy; this.wrap = false; }
+      else if (c == "D") this.lf();
+      else if (c == "E") { this.x = 0; this.lf(); }
+      else if (c == "M") { if (this.y == this.top) this.scroll(1, false); else if (this.y > 0) this.y--; }
+      else if (c == "c") this.reset();
+      break;
+    case 2:
+      if (code >= 0x40 && code <= 0x7e) { this.state = 0; this.csi(c); }
+      else this.params += c;
+      break;
+    case 3:
+      if (c == "\x07") this.state = 0;
+      else if (c == "\x1b") this.state = 4;
+      break;
+    case 4:
+    case 5:
+      this.state = 0;
+      break;
+    }
+  }
+};
+
+Term.prototype.html = function () {
+  var out = "";
+  for (var y = 0; y < this.rows; y++) {
+    var run = "", key = "";
+    for (var x = 0; x <= this.cols; x++) {
+      var k = "";
+      if (x < this.cols) {
+        var a = this.cells[y][x].a, fg = a.fg, bg = a.bg, cls = a.bold ? "b" : "";
+        if (a.inv) { fg = a.bg === undefined ? 0 : a.bg; bg = a.fg === undefined ? 7 : a.fg; }
+        if (!this.hidden && x == this.x && y == this.y) cls += " cursor";
+        k = "class=\"" + cls + "\" style=\"" +
+          (fg === undefined ? "" : "color:" + colors[fg] + ";") +
+          (bg === undefined ? "" : "background:" + colors[bg] + ";") + "\"";
+      }
+      if (k != key || x == this.cols) {
+        out += key == "class=\"\" style=\"\"" || !run ? run : "<span " + key + ">" + run + "</span>";
+        run = ""; key = k;
+      }
+      if (x < this.cols) run += this.cells[y][x].c.replace(/&/g, "&amp;").replace(/</g, "&lt;");
+    }
+    out += "\n";
+  }
+  return out;
+};
+
+var term = null, pending = false;
+
+function render() {
+  pending = false;
+  document.getElementById("screen").innerHTML = term.html();
+}
+
+function connect() {
+  var status = document.getElementById("status");
+  var ws = new WebSocket((location.protocol == "https:" ? "wss://" : "ws://") + location.host + "/ws");
+  ws.onopen = function () { status.textContent = "live"; };
+  ws.onclose = function () { status.textContent = "disconnected"; setTimeout(connect, 2000); };
+  ws.onmessage = function (e) {
+    var msg = JSON.parse(e.data);
+    if (!Array.isArray(msg)) {
+      term = new Term(msg.width, msg.height);
+    } else if (term && msg[1] == "o") {
+      term.write(msg[2]);
+    } else if (term && msg[1] == "r") {
+      var size = msg[2].split("x");
+      term.resize(Number(size[0]), Number(size[1]));
+    }
+    if (term && !pending) { pending = true; requestAnimationFrame(render); }
+  };
+}
+
+connect();
+</script>
+</body>
+</html>
+`
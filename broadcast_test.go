@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWSAccept(t *testing.T) {
+	// The example from RFC 6455.
+	got := wsAccept("dGhlIHNhbXBsZSBub25jZQ==")
+	if got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestWSFrames(t *testing.T) {
+	for _, n := range []int{0, 125, 126, 65535, 70000} {
+		payload := bytes.Repeat([]byte("x"), n)
+
+		for _, mask := range [][]byte{nil, {1, 2, 3, 4}} {
+			var buf bytes.Buffer
+			err := wsWriteFrame(&buf, wsText, payload, mask)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			op, got, err := wsReadFrame(&buf)
+			if n > wsMaxPayload {
+				if err == nil {
+					t.Errorf("expected an error for %d bytes", n)
+				}
+				continue
+			}
+			if err != nil || op != wsText || !bytes.Equal(got, payload) {
+				t.Errorf("%d bytes, mask %v: op %d, %d bytes, %v", n, mask, op, len(got), err)
+			}
+		}
+	}
+}
+
+// wsDial connects a test client to a websocket.
+func wsDial(t *testing.T, url string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\n"+
+		"Connection: keep-alive, Upgrade\r\nSec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		t.Fatalf("unexpected handshake response %v", resp)
+	}
+
+	return conn, r
+}
+
+func wsNext(t *testing.T, r io.Reader) []any {
+	t.Helper()
+
+	op, payload, err := wsReadFrame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op != wsText {
+		t.Fatalf("unexpected opcode %d", op)
+	}
+
+	var msg any
+	err = json.Unmarshal(payload, &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event, ok := msg.([]any); ok {
+		return event
+	}
+	return []any{msg}
+}
+
+func TestBroadcaster(t *testing.T) {
+	b := NewBroadcaster(3, 10)
+	server := httptest.NewServer(b)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "new WebSocket(") {
+		t.Errorf("unexpected page %q", page)
+	}
+
+	// A late joiner gets the screen so far, split UTF-8 is held back.
+	b.Write([]byte("\x1B[32mhello\x1B[0m\r\n\xc3"))
+
+	conn, r := wsDial(t, server.URL)
+	defer conn.Close()
+
+	header := wsNext(t, r)[0].(map[string]any)
+	if header["version"] != 2.0 || header["width"] != 10.0 || header["height"] != 3.0 {
+		t.Errorf("unexpected header %v", header)
+	}
+
+	snapshot := wsNext(t, r)
+	if snapshot[1] != "o" || !strings.Contains(snapshot[2].(string), "hello\r\n") {
+		t.Errorf("unexpected snapshot %q", snapshot)
+	}
+
+	b.Write([]byte("\xa9!"))
+	b.Resize(4, 20)
+
+	event := wsNext(t, r)
+	if event[1] != "o" || event[2] != "é!" {
+		t.Errorf("unexpected output %q", event)
+	}
+	event = wsNext(t, r)
+	if event[1] != "r" || event[2] != "20x4" {
+		t.Errorf("unexpected resize %q", event)
+	}
+
+	// Closing is acknowledged and the client is forgotten.
+	err = wsWriteFrame(conn, wsClose, []byte{0x03, 0xE8}, []byte{9, 8, 7, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, _, err := wsReadFrame(r)
+	if err != nil || op != wsClose {
+		t.Errorf("expected a close frame, got %d (%v)", op, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		n := len(b.clients)
+		b.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the client was not removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBroadcasterSlowClient(t *testing.T) {
+	b := NewBroadcaster(3, 10)
+	c := &wsClient{out: make(chan wsMessage, 1)}
+	b.clients[c] = struct{}{}
+
+	b.Write([]byte("a"))
+	b.Write([]byte("b"))
+
+	if len(b.clients) != 0 {
+		t.Errorf("expected the slow client to be dropped")
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Conversion of asciinema recordings into scripts. A recording holds what
+// the terminal showed, and what was typed when it was recorded with --stdin.
+// The commands are taken from what was typed when there is any, else from
+// the lines of the output which start with a prompt. The output of every
+// command is kept in comments, to review the script against.
+
+// defaultPrompt matches prompts like "user@host:~$ ", "[root@host tmp]# "
+// and a bare "$ ", possibly after the name of a virtualenv. Anything looser
+// matches output, like the header of df.
+var defaultPrompt = regexp.MustCompile(`^(?:\([^()\s]+\) )?(?:[\w.-]+@[\w.-]+(?::[^\s$#]*| [^\s$#]+ )?[$#]|\[[\w.-]+@[\w.-]+(?: [^\]]*)?\][$#]|\$) `)
+
+// castOutputLines is how many lines of the output of a command are kept.
+const castOutputLines = 10
+
+// castColumns is as far right as castLines moves the cursor.
+const castColumns = 1000
+
+type castEvent struct {
+	line int
+	time float64
+	kind string // "o" for output, "i" for input
+	data string
+}
+
+// readCast reads the events of an asciicast v1 or v2 file.
+func readCast(source string) ([]castEvent, error) {
+	var v1 struct {
+		Version int               `json:"version"`
+		Stdout  []json.RawMessage `json:"stdout"`
+	}
+	if json.Unmarshal([]byte(source), &v1) == nil && v1.Version == 1 {
+		var (
+			events []castEvent
+			at     float64
+		)
+		for _, raw := range v1.Stdout {
+			var e [2]any
+			json.Unmarshal(raw, &e)
+			delay, ok1 := e[0].(float64)
+			data, ok2 := e[1].(string)
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("invalid frame %s.", raw)
+			}
+			at += delay
+			events = append(events, castEvent{time: at, kind: "o", data: data})
+		}
+		return events, nil
+	}
+
+	lines := strings.Split(source, "\n")
+	var header struct {
+		Version int `json:"version"`
+	}
+	err := json.Unmarshal([]byte(lines[0]), &header)
+	if err != nil {
+		return nil, errors.New("not an asciicast file.")
+	}
+	if header.Version != 2 {
+		return nil, fmt.Errorf("asciicast version %d is not supported.", header.Version)
+	}
+
+	var events []castEvent
+	for i, line := range lines[1:] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var e [3]any
+		err := json.Unmarshal([]byte(line), &e)
+		at, ok1 := e[0].(float64)
+		kind, ok2 := e[1].(string)
+		data, ok3 := e[2].(string)
+		if err != nil || !ok1 || !ok2 || !ok3 {
+			return nil, fmt.Errorf("line %d: invalid event.", i+2)
+		}
+		events = append(events, castEvent{line: i + 2, time: at, kind: kind, data: data})
+	}
+	return events, nil
+}
+
+// ImportCast converts an asciinema recording into a script. When prompt is
+// set, or nothing typed was recorded, the commands are the lines of the
+// output which match prompt, or defaultPrompt.
+func ImportCast(source string, prompt *regexp.Regexp) (Script, []Warning, error) {
+	events, err := readCast(source)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if prompt == nil {
+		for _, e := range events {
+			if e.kind == "i" {
+				script, warnings := castInput(events)
+				return script, warnings, nil
+			}
+		}
+		prompt = defaultPrompt
+	}
+	script, warnings := castPrompts(events, prompt)
+	return script, warnings, nil
+}
+
+// castInput takes the commands from the keys typed at the prompt, like a
+// tape does.
+func castInput(events []castEvent) (Script, []Warning) {
+	var (
+		t       = &tapeImporter{rate: typingRate}
+		outputs = map[*OpExec]string{}
+		run     *OpExec
+		last    time.Duration
+	)
+
+	for _, e := range events {
+		at := time.Duration(e.time * float64(time.Second))
+
+		switch e.kind {
+		case "i":
+			if t.run != nil {
+				if breath := learnedBreath(at-last, t.input == ""); breath != nil {
+					t.add(e.line, breath)
+				}
+			}
+			last = at
+
+			n := len(t.script)
+			t.send(e.line, strings.ReplaceAll(e.data, "\r", "\n"))
+			// Whatever is entered at the prompt ends the output of the last
+			// command.
+			for _, op := range t.script[n:] {
+				run, _ = op.(*OpExec)
+			}
+
+		case "o":
+			if run != nil {
+				outputs[run] += e.data
+			}
+		}
+	}
+
+	script, warnings := t.finish()
+
+	var out Script
+	for _, op := range script {
+		out = append(out, op)
+		if x, ok := op.(*OpExec); ok {
+			// The output starts on the line of the command and ends on the
+			// prompt of the next one.
+			lines := castLines(outputs[x])
+			if len(lines) > 2 {
+				out = append(out, outputComments(lines[1:len(lines)-1])...)
+			}
+		}
+	}
+	return out, warnings
+}
+
+// castPrompts takes the commands from the lines of the output which match
+// prompt.
+func castPrompts(events []castEvent, prompt *regexp.Regexp) (Script, []Warning) {
+	var output strings.Builder
+	for _, e := range events {
+		if e.kind == "o" {
+			output.WriteString(e.data)
+		}
+	}
+
+	var (
+		script Script
+		run    *OpExec
+		lines  []string
+		found  bool
+	)
+
+	flush := func() {
+		if run != nil {
+			script = append(script, run)
+			script = append(script, outputComments(lines)...)
+		}
+		run, lines = nil, nil
+	}
+
+	for _, line := range castLines(output.String()) {
+		m := prompt.FindStringIndex(line)
+		if m == nil {
+			lines = append(lines, line)
+			continue
+		}
+
+		flush()
+		found = true
+		cmd := strings.TrimSpace(line[m[1]:])
+		switch {
+		case cmd == "":
+		case strings.HasPrefix(cmd, "#"):
+			script = append(script, &OpEcho{content: strings.TrimSpace(cmd[1:])})
+		default:
+			run = &OpExec{cmd: cmd}
+		}
+	}
+	flush()
+
+	if !found {
+		return nil, []Warning{{Pos: Pos{Line: 1}, Msg: "no line of the output looks like a prompt, match it with --prompt."}}
+	}
+	return script, nil
+}
+
+// outputComments returns the comments which show the output of a command.
+func outputComments(lines []string) Script {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var comments Script
+	for i, line := range lines {
+		if i == castOutputLines {
+			comments = append(comments, &OpComment{text: fmt.Sprintf("# > and %d more lines", len(lines)-i)})
+			break
+		}
+		comments = append(comments, &OpComment{text: strings.TrimRight("# > "+line, " ")})
+	}
+	return comments
+}
+
+// castLines renders output into lines of text, the last one is the line the
+// cursor ended up on. Only the movements of the cursor within a line are
+// followed, other escape sequences are dropped. Trailing spaces are kept as
+// prompts end in one.
+func castLines(output string) []string {
+	var (
+		lines []string
+		line  []rune
+		col   int
+		rs    = []rune(output)
+	)
+
+	put := func(r rune) {
+		for len(line) < col {
+			line = append(line, ' ')
+		}
+		if col < len(line) {
+			line[col] = r
+		} else {
+			line = append(line, r)
+		}
+		col++
+	}
+
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '\n':
+			lines = append(lines, string(line))
+			line, col = nil, 0
+		case r == '\r':
+			col = 0
+		case r == '\b':
+			col = max(0, col-1)
+		case r == '\t':
+			col = min(castColumns, (col/8+1)*8)
+		case r == 0x1B && i+1 < len(rs) && rs[i+1] == '[':
+			j := i + 2
+			for j < len(rs) && (rs[j] < 0x40 || rs[j] > 0x7E) {
+				j++
+			}
+			if j == len(rs) {
+				i = j
+				break
+			}
+			n, err := strconv.Atoi(string(rs[i+2 : j]))
+			n = min(n, castColumns)
+			switch rs[j] {
+			case 'K':
+				if n == 2 {
+					line = nil
+				} else if n == 0 && col < len(line) {
+					line = line[:col]
+				}
+			case 'C':
+				col = min(castColumns, col+max(1, n))
+			case 'D':
+				col = max(0, col-max(1, n))
+			case 'G':
+				if err == nil {
+					col = min(castColumns, max(0, n-1))
+				} else {
+					col = 0
+				}
+			}
+			i = j
+		case r == 0x1B && i+1 < len(rs) && rs[i+1] == ']':
+			// Up to BEL or ST.
+			j := i + 2
+			for j < len(rs) && rs[j] != 0x07 && !(rs[j] == 0x1B && j+1 < len(rs) && rs[j+1] == '\\') {
+				j++
+			}
+			if j < len(rs) && rs[j] == 0x1B {
+				j++
+			}
+			i = j
+		case r == 0x1B:
+			i++
+		case r < ' ' || r == 0x7F:
+		default:
+			put(r)
+		}
+	}
+
+	return append(lines, string(line))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const testCast = `{"version": 2, "width": 80, "height": 24}
+[0.1, "o", "user@host:~$ "]
+[0.5, "i", "l"]
+[0.5, "o", "l"]
+[0.6, "i", "x"]
+[0.6, "o", "x"]
+[0.7, "i", "\u007f"]
+[0.7, "o", "\b\u001b[K"]
+[0.8, "i", "s\r"]
+[0.8, "o", "s\r\n"]
+[0.9, "o", "a.txt\r\nb.txt\r\nuser@host:~$ "]
+[1.0, "i", "# done\r"]
+[1.0, "o", "# done\r\nuser@host:~$ "]
+[1.5, "i", "less a.txt\r"]
+[1.5, "o", "less a.txt\r\n\u001b[?1049hhello\r\n"]
+[3.5, "i", "q"]
+[3.6, "o", "\u001b[?1049luser@host:~$ "]
+[4.0, "i", "ls\t"]
+`
+
+func TestImportCast(t *testing.T) {
+	script, warnings, err := ImportCast(testCast, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Format(script)
+	want := `RUN ls
+# > a.txt
+# > b.txt
+SAY done
+RUN less a.txt
+- BREATH 1.5s
+- TYPE q
+# > hello
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if len(warnings) != 2 || warnings[0].Line != 18 || warnings[0].Msg != "<HT> at the prompt is not supported." {
+		t.Errorf("unexpected warnings %+v", warnings)
+	}
+
+	script, _, err = ImportCast(testCast, regexp.MustCompile(`\$ `))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = Format(script)
+	want = `RUN ls
+# > a.txt
+# > b.txt
+SAY done
+RUN less a.txt
+# > hello
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestImportCastEdited(t *testing.T) {
+	cast := `{"version": 2, "width": 80, "height": 24}
+[0.1, "i", "ls -l\u001b[D\u001b[Da\r"]
+[0.2, "i", "\u0012ssh\r"]
+[0.3, "i", "\u001b[A\r"]
+[0.4, "i", "pwd\r"]
+`
+
+	script, warnings, err := ImportCast(cast, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Format(script); got != "RUN pwd\n" {
+		t.Errorf("got:\n%s", got)
+	}
+
+	var msgs []string
+	for _, w := range warnings {
+		msgs = append(msgs, w.Msg)
+	}
+	want := []string{
+		"<ESC>[D at the prompt is not supported.",
+		"<ESC>[D at the prompt is not supported.",
+		`"ls -la" is dropped, it was edited with keys which are not supported.`,
+		"<DC2> at the prompt is not supported.",
+		`"ssh" is dropped, it was edited with keys which are not supported.`,
+		"<ESC>[A at the prompt is not supported.",
+		"a command from the history is dropped.",
+	}
+	if !reflect.DeepEqual(msgs, want) {
+		t.Errorf("got %q, want %q", msgs, want)
+	}
+}
+
+func TestDefaultPrompt(t *testing.T) {
+	for _, line := range []string{"$ ls", "user@host:~$ ls", "root@box:/# ls", "user@host ~ $ ls", "[user@host tmp]$ ls", "(venv) user@host:~/src$ ls"} {
+		if m := defaultPrompt.FindStringIndex(line); m == nil || line[m[1]:] != "ls" {
+			t.Errorf("%q is not a prompt", line)
+		}
+	}
+	for _, line := range []string{"Filesystem  Size  Use% Mounted on", "100% done", "a > b", "# a comment", "cost: $ 5", "PS1='$ '"} {
+		if defaultPrompt.MatchString(line) {
+			t.Errorf("%q is a prompt", line)
+		}
+	}
+
+	script, warnings, err := ImportCast(`{"version": 2}
+[0.1, "o", "Use% Mounted on\r\n100% done\r\n"]
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(script) != 0 || len(warnings) != 1 || warnings[0].Msg != "no line of the output looks like a prompt, match it with --prompt." {
+		t.Errorf("unexpected script %q and warnings %+v", Format(script), warnings)
+	}
+}
+
+func TestImportCastV1(t *testing.T) {
+	var lines []string
+	for i := range 12 {
+		lines = append(lines, `"line `+string(rune('a'+i))+`\r\n"`)
+	}
+	cast := `{"version": 1, "width": 80, "height": 24, "stdout": [
+  [0.1, "$ seq 12\r\n"],
+  [0.2, ` + strings.Join(lines, `], [0.1, `) + `],
+  [0.1, "$ "]
+]}`
+
+	script, _, err := ImportCast(cast, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "RUN seq 12\n"
+	for i := range castOutputLines {
+		want += "# > line " + string(rune('a'+i)) + "\n"
+	}
+	want += "# > and 2 more lines\n"
+	if got := Format(script); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestImportCastErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{"RUN ls\n", "not an asciicast file."},
+		{`{"version": 3}`, "asciicast version 3 is not supported."},
+		{"{\"version\": 2}\n[0.1, \"o\"]\n", "line 2: invalid event."},
+	}
+
+	for _, test := range tests {
+		_, _, err := ImportCast(test.source, nil)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%q: got %v, want %s", test.source, err, test.err)
+		}
+	}
+}
+
+func TestCastLines(t *testing.T) {
+	got := castLines("abc\x1B[2Dx\r\nfoo\bO\x1B[K\x1B]0;title\x07\r\n\ttab\x1B[1;31mred\x1B[0m\r\n$ ")
+	want := []string{"axc", "foO", "        tabred", "$ "}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, output := range []string{"\x1B[99999999Cx", "\x1B[99999999999999999999999Cx", "\x1B[99999999Gx", strings.Repeat("\t", 1000) + "x"} {
+		got := castLines(output)
+		if len(got) != 1 || len(got[0]) > castColumns+1 {
+			t.Errorf("%q: got a line of %d columns", output, len(got[0]))
+		}
+	}
+}
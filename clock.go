@@ -0,0 +1,26 @@
+package main
+
+import "time"
+
+// Clock is the source of time for a running script. All delays go through
+// it so scripts can be run without them, or against a fake clock in tests.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time                         { return time.Now() }
+func (wallClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// fastClock tells the time but never waits.
+type fastClock struct{}
+
+func (fastClock) Now() time.Time { return time.Now() }
+
+func (fastClock) After(time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	c <- time.Now()
+	return c
+}
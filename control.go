@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Control lets a presenter drive a running script: step through it, pause
+// it, change its pace or jump to another section. It is served over HTTP
+// by ServeHTTP and is told about the progress of the script as an Observer.
+type Control struct {
+	script Script
+
+	mu      sync.Mutex
+	wake    chan struct{}
+	step    bool
+	paused  bool
+	next    bool
+	jump    int
+	speed   float64
+	index   int
+	waiting bool
+	done    bool
+}
+
+type ControlStatus struct {
+	State   string  `json:"state"`
+	Step    int     `json:"step"`
+	Steps   int     `json:"steps"`
+	Op      string  `json:"op,omitempty"`
+	Section string  `json:"section,omitempty"`
+	Speed   float64 `json:"speed"`
+}
+
+// NewControl returns a control for script. In step mode every op waits for
+// Next.
+func NewControl(script Script, step bool) *Control {
+	return &Control{
+		script: script,
+		wake:   make(chan struct{}),
+		step:   step,
+		jump:   -1,
+		speed:  1,
+		index:  -1,
+	}
+}
+
+// changed wakes up the script. It must be called with the lock held.
+func (c *Control) changed() {
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+// wait blocks until the op at index i may run and returns the index of the
+// op to run, which is different from i after a jump. Ops the audience does
+// not see never wait for Next.
+func (c *Control) wait(ctx context.Context, i int) (int, error) {
+	for {
+		c.mu.Lock()
+		if c.jump >= 0 {
+			i, c.jump = c.jump, -1
+		}
+		free := i < len(c.script) && silent(c.script[i])
+		if c.next || !c.paused && (!c.step || free) {
+			if !free {
+				c.next = false
+			}
+			c.waiting = false
+			c.mu.Unlock()
+			return i, nil
+		}
+		c.index = i
+		c.waiting = true
+		wake := c.wake
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return i, ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+func (c *Control) scale(d time.Duration) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Duration(float64(d) / c.speed)
+}
+
+func (c *Control) Observe(e *ExecEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case e.Kind == EventStart && e.Step > 0:
+		c.index = e.Step - 1
+	case e.Kind == EventEnd:
+		c.done = true
+	}
+}
+
+// Next lets the next op run, even when the script is paused.
+func (c *Control) Next() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next = true
+	c.changed()
+}
+
+// Pause stops the script before the next op.
+func (c *Control) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = true
+	c.changed()
+}
+
+// Resume continues a paused script. In step mode ops still wait for Next.
+func (c *Control) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = false
+	c.changed()
+}
+
+// SetSpeed changes the pace of typing and pauses; 2 runs twice as fast.
+func (c *Control) SetSpeed(speed float64) error {
+	if !(speed > 0) || speed > 100 {
+		return errors.New("the speed must be between 0 and 100.")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.speed = speed
+	return nil
+}
+
+// Jump continues the script at a section, given by its title or number,
+// after the current op.
+func (c *Control) Jump(section string) error {
+	n, err := strconv.Atoi(section)
+	if err != nil {
+		n = 0
+	}
+
+	var count int
+	for i, op := range c.script {
+		x, ok := op.(*OpSection)
+		if !ok {
+			continue
+		}
+		count++
+		if count == n || strings.EqualFold(x.title, section) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.jump = i
+			c.changed()
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown section %q.", section)
+}
+
+func (c *Control) Status() *ControlStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := &ControlStatus{State: "running", Speed: c.speed}
+	switch {
+	case c.done:
+		st.State = "done"
+	case c.paused:
+		st.State = "paused"
+	case c.waiting:
+		st.State = "waiting"
+	}
+
+	// Like the presenter notes, ops the audience does not see belong to
+	// the op which follows them.
+	i := max(c.index, 0)
+	for i < len(c.script) && silent(c.script[i]) {
+		i++
+	}
+
+	for j, op := range c.script {
+		if silent(op) {
+			if x, ok := op.(*OpSection); ok && j <= i {
+				st.Section = x.title
+			}
+			continue
+		}
+		st.Steps++
+		if j <= i {
+			st.Step = st.Steps
+		}
+	}
+
+	if i < len(c.script) && !c.done {
+		st.Op = describe(c.script[i])
+	}
+
+	return st
+}
+
+func (c *Control) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		cmd = strings.TrimPrefix(r.URL.Path, "/")
+		arg = r.URL.Query().Get("arg")
+		err error
+	)
+
+	if cmd != "status" && r.Method != http.MethodPost {
+		http.Error(w, "use POST to control the script.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch cmd {
+	case "status":
+	case "next":
+		c.Next()
+	case "pause":
+		c.Pause()
+	case "resume":
+		c.Resume()
+	case "speed":
+		speed, perr := strconv.ParseFloat(arg, 64)
+		if perr != nil {
+			err = fmt.Errorf("invalid speed %q.", arg)
+		} else {
+			err = c.SetSpeed(speed)
+		}
+	case "jump":
+		err = c.Jump(arg)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Status())
+}
+
+// listenControl listens on a TCP address or on unix:<path>. A socket left
+// behind at path by an earlier run is replaced, anything else is not.
+func listenControl(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket.", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use.", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return net.Listen("unix", path)
+}
+
+// controlClient returns a client and base URL for a control server address.
+func controlClient(addr string) (*http.Client, string) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return http.DefaultClient, "http://" + addr
+	}
+
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}, "http://unix"
+}
+
+// Ctl sends cmd to the control server at addr and returns its status.
+func Ctl(addr, cmd, arg string) (*ControlStatus, error) {
+	client, base := controlClient(addr)
+
+	u := base + "/" + url.PathEscape(cmd)
+	if arg != "" {
+		u += "?arg=" + url.QueryEscape(arg)
+	}
+
+	var (
+		resp *http.Response
+		err  error
+	)
+	if cmd == "status" {
+		resp, err = client.Get(u)
+	} else {
+		resp, err = client.Post(u, "", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, errors.New(strings.TrimSpace(string(msg)))
+	}
+
+	var st ControlStatus
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (st *ControlStatus) Report(w io.Writer) {
+	fmt.Fprintf(w, "state:   %s\n", st.State)
+	fmt.Fprintf(w, "step:    %d of %d\n", st.Step, st.Steps)
+	if st.Op != "" {
+		fmt.Fprintf(w, "op:      %s\n", st.Op)
+	}
+	if st.Section != "" {
+		fmt.Fprintf(w, "section: %s\n", st.Section)
+	}
+	fmt.Fprintf(w, "speed:   %g\n", st.Speed)
+}
+
+func runCtl(addr, cmd, arg string) {
+	switch cmd {
+	case "status", "next", "pause", "resume":
+	case "speed", "jump":
+		if arg == "" {
+			fmt.Fprintf(os.Stderr, "error: %s needs an argument.\n", cmd)
+			os.Exit(2)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown command %q.\n", cmd)
+		os.Exit(2)
+	}
+
+	st, err := Ctl(addr, cmd, arg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+	st.Report(os.Stdout)
+}
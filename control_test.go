@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitForState(t *testing.T, c *Control, state string) *ControlStatus {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		st := c.Status()
+		if st.State == state {
+			return st
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("timeout waiting for %s, status %+v", state, c.Status())
+	return nil
+}
+
+func TestControl(t *testing.T) {
+	script, err := Parse("SECTION A\nRUN echo one\nSECTION B\nRUN echo two\nSECTION C\nRUN echo three")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		c    = NewControl(script, true)
+		l, _ = listenControl("unix:" + filepath.Join(t.TempDir(), "ctl.sock"))
+		addr = "unix:" + l.Addr().String()
+		runs []string
+		done = make(chan error)
+	)
+	go http.Serve(l, c)
+	defer l.Close()
+
+	go func() {
+		done <- Exec(context.Background(), &bytes.Buffer{}, script, Options{
+			Headless: true,
+			Shell:    &fakeShell{pty: newFakePty(echoRun)},
+			Clock:    newFakeClock(),
+			Observer: c,
+			Control:  c,
+			OnRun:    func(r *RunResult) { runs = append(runs, r.Cmd) },
+		})
+	}()
+
+	st := waitForState(t, c, "waiting")
+	if st.Step != 1 || st.Steps != 3 || st.Op != "RUN echo one" || st.Section != "A" {
+		t.Errorf("unexpected status %+v", st)
+	}
+
+	_, err = Ctl(addr, "jump", "x")
+	if err == nil || err.Error() != `unknown section "x".` {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = Ctl(addr, "speed", "0")
+	if err == nil || err.Error() != "the speed must be between 0 and 100." {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	st, err = Ctl(addr, "speed", "2")
+	if err != nil || st.Speed != 2 {
+		t.Errorf("unexpected status %+v (%v)", st, err)
+	}
+	if d := c.scale(time.Second); d != 500*time.Millisecond {
+		t.Errorf("unexpected scaled delay %s", d)
+	}
+
+	_, err = Ctl(addr, "jump", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Status().Section != "C" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	st = c.Status()
+	if st.State != "waiting" || st.Op != "RUN echo three" || st.Step != 3 {
+		t.Errorf("unexpected status %+v", st)
+	}
+
+	c.Pause()
+	c.Resume()
+	if st := c.Status(); st.State != "waiting" {
+		t.Errorf("step mode should still wait, status %+v", st)
+	}
+
+	_, err = Ctl(addr, "next", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0] != "echo three" {
+		t.Errorf("unexpected runs %q", runs)
+	}
+
+	st, err = Ctl(addr, "status", "")
+	if err != nil || st.State != "done" {
+		t.Errorf("unexpected status %+v (%v)", st, err)
+	}
+}
+
+func TestControlPause(t *testing.T) {
+	c := NewControl(Script{&OpEcho{content: "a"}, &OpEcho{content: "b"}}, false)
+	c.Pause()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan int)
+	go func() {
+		i, _ := c.wait(ctx, 1)
+		result <- i
+	}()
+
+	waitForState(t, c, "paused")
+	c.Resume()
+	if i := <-result; i != 1 {
+		t.Errorf("unexpected index %d", i)
+	}
+
+	c.Pause()
+	go func() {
+		_, err := c.wait(ctx, 0)
+		if err != context.Canceled {
+			t.Errorf("unexpected error: %v", err)
+		}
+		result <- 0
+	}()
+	cancel()
+	<-result
+}
+
+func TestListenControlUnix(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		file = filepath.Join(dir, "file")
+		sock = filepath.Join(dir, "ctl.sock")
+	)
+
+	os.WriteFile(file, []byte("keep"), 0644)
+	_, err := listenControl("unix:" + file)
+	if err == nil || !strings.Contains(err.Error(), "is not a socket") {
+		t.Errorf("expected an error, got %v", err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "keep" {
+		t.Errorf("the file was removed")
+	}
+
+	l, err := listenControl("unix:" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = listenControl("unix:" + sock)
+	if err == nil || !strings.Contains(err.Error(), "is in use") {
+		t.Errorf("expected an error, got %v", err)
+	}
+
+	// Left behind by a run which did not clean up.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	l, err = listenControl("unix:" + sock)
+	if err != nil {
+		t.Fatalf("the stale socket was not replaced: %s", err)
+	}
+	l.Close()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// runImport converts a file of another tool into a script on stdout. prompt
+// is only used for recordings.
+func runImport(kind, name, prompt string) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+
+	var (
+		script   Script
+		warnings []Warning
+	)
+
+	switch kind {
+	case "tape":
+		script, warnings = ImportTape(string(data))
+	case "cast":
+		var re *regexp.Regexp
+		if prompt != "" {
+			re, err = regexp.Compile(prompt)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: invalid prompt: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		script, warnings, err = ImportCast(string(data), re)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %s\n", name, err)
+			os.Exit(1)
+		}
+	}
+
+	header := &OpComment{text: "# Imported from " + filepath.Base(name) + "."}
+	fmt.Print(Format(append(Script{header}, script...)))
+	writeWarnings(os.Stderr, name, warnings)
+}
+
+// runExport converts a script into a file for another tool on stdout.
+func runExport(kind, name string) {
+	script, err := ParseFile(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	var (
+		out      string
+		warnings []Warning
+	)
+
+	switch kind {
+	case "tape":
+		base := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+		out, warnings = ExportTape(script, base+".gif")
+	case "sh":
+		out = ExportShell(script, filepath.Base(name))
+	}
+
+	fmt.Print(out)
+	writeWarnings(os.Stderr, name, warnings)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Observer is told about everything that happens while a script runs.
+// Observe may be called from several goroutines.
+type Observer interface {
+	Observe(e *ExecEvent)
+}
+
+// Observers passes events on to all of its observers.
+type Observers []Observer
+
+func (o Observers) Observe(e *ExecEvent) {
+	for _, x := range o {
+		x.Observe(e)
+	}
+}
+
+// ExecEvent kinds.
+const (
+	EventStart  = "start"  // an op starts
+	EventFinish = "finish" // an op finished
+	EventType   = "type"   // characters were typed
+	EventOutput = "output" // a chunk of command output
+	EventExit   = "exit"   // a RUN exited
+	EventEnd    = "end"    // the script ended
+)
+
+type ExecEvent struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"event"`
+	Step     int       `json:"step,omitempty"` // 1-based, top level ops only
+	Op       string    `json:"op,omitempty"`
+	Data     string    `json:"data,omitempty"`
+	Status   *uint8    `json:"status,omitempty"`
+	Duration float64   `json:"duration,omitempty"` // seconds
+	Err      string    `json:"error,omitempty"`
+}
+
+// emit sends e to the observer of the session.
+func (s *Session) emit(e *ExecEvent) {
+	if s.observer == nil || s.replaying {
+		return
+	}
+	e.Time = s.clock.Now()
+	s.observer.Observe(e)
+}
+
+// eventWriter emits everything written to it as output events.
+type eventWriter struct {
+	s *Session
+}
+
+func (w eventWriter) Write(p []byte) (int, error) {
+	w.s.emit(&ExecEvent{Kind: EventOutput, Data: string(p)})
+	return len(p), nil
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// JSONEvents writes events as JSON lines.
+type JSONEvents struct {
+	mu  sync.Mutex
+	w   io.WriteCloser
+	err error
+}
+
+// OpenEvents opens the events sink described by spec, which is either a file
+// name, fd:<n> for an inherited file descriptor or unix:<path> for a unix
+// socket.
+func OpenEvents(spec string) (*JSONEvents, error) {
+	var (
+		w   io.WriteCloser
+		err error
+	)
+
+	switch {
+	case strings.HasPrefix(spec, "fd:"):
+		fd, perr := strconv.Atoi(spec[3:])
+		if perr != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid file descriptor %q.", spec[3:])
+		}
+		w = os.NewFile(uintptr(fd), spec)
+	case strings.HasPrefix(spec, "unix:"):
+		w, err = net.Dial("unix", spec[5:])
+	default:
+		w, err = os.Create(spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &JSONEvents{w: w}, nil
+}
+
+func (j *JSONEvents) Observe(e *ExecEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.err != nil {
+		return
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		j.err = err
+		return
+	}
+
+	_, j.err = j.w.Write(append(data, '\n'))
+}
+
+// Close closes the sink and returns the first error that occurred.
+func (j *JSONEvents) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	err := j.w.Close()
+	if j.err != nil {
+		return j.err
+	}
+	return err
+}
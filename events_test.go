@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type eventLog struct {
+	mu     sync.Mutex
+	events []*ExecEvent
+}
+
+func (l *eventLog) Observe(e *ExecEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+}
+
+func TestObserver(t *testing.T) {
+	var log eventLog
+
+	script, err := Parse("SAY hi\nRUN echo hello\n- EXPECT 0\nRUN exit 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Exec(context.Background(), &bytes.Buffer{}, script, Options{
+		Headless: true,
+		Shell:    &fakeShell{pty: newFakePty(echoRun)},
+		Clock:    newFakeClock(),
+		Observer: &log,
+	})
+
+	var (
+		kinds  []string
+		typed  string
+		output string
+	)
+	for _, e := range log.events {
+		switch e.Kind {
+		case EventType:
+			typed += e.Data
+			continue
+		case EventOutput:
+			output += e.Data
+			continue
+		case EventExit:
+			kinds = append(kinds, e.Kind+" "+e.Op+" "+string('0'+*e.Status))
+			continue
+		}
+		kinds = append(kinds, strings.Join(strings.Fields(e.Kind+" "+e.Op+" "+e.Err), " "))
+		if e.Kind == EventStart && e.Op == "RUN echo hello" && e.Step != 2 {
+			t.Errorf("expected step 2, got %d", e.Step)
+		}
+	}
+
+	want := []string{
+		"start SAY hi",
+		"finish SAY hi",
+		"start RUN echo hello",
+		"exit RUN echo hello 0",
+		"finish RUN echo hello",
+		"start RUN exit 2",
+		"exit RUN exit 2 2",
+		"finish RUN exit 2 the command exited with status 2.",
+		"end the command exited with status 2.",
+	}
+	if strings.Join(kinds, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got events\n%s\nwant\n%s", strings.Join(kinds, "\n"), strings.Join(want, "\n"))
+	}
+
+	if !strings.HasPrefix(typed, "# hiecho helloexit 2") {
+		t.Errorf("unexpected typed characters %q", typed)
+	}
+	if !strings.Contains(output, "hello\r\n") {
+		t.Errorf("unexpected output %q", output)
+	}
+}
+
+func TestJSONEvents(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "events.jsonl")
+
+	sink, err := OpenEvents(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := uint8(0)
+	sink.Observe(&ExecEvent{Kind: EventStart, Step: 1, Op: "RUN true"})
+	sink.Observe(&ExecEvent{Kind: EventExit, Op: "RUN true", Status: &status})
+
+	err = sink.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines []map[string]any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line map[string]any
+		err := json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	if lines[0]["event"] != "start" || lines[0]["step"] != 1.0 {
+		t.Errorf("unexpected first line %v", lines[0])
+	}
+	if lines[1]["status"] != 0.0 {
+		t.Errorf("expected the zero status to be kept, got %v", lines[1])
+	}
+}
+
+func TestOpenEventsInvalid(t *testing.T) {
+	_, err := OpenEvents("fd:x")
+	if err == nil || err.Error() != `invalid file descriptor "x".` {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
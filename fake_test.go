@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeClock advances only when waited on.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	total time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.total += d
+
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func (c *fakeClock) Total() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+// fakeRun handles the lines typed since the last prompt. It returns false
+// when the command is waiting for more input.
+type fakeRun func(lines []string) (output string, status uint8, done bool)
+
+// fakePty emulates the instrumented bash: it echoes typed input, passes
+// every complete line to run and then prints the prompt marker. Every
+// command takes 250ms according to the markers.
+type fakePty struct {
+	mu      sync.Mutex
+	nonce   string
+	cond    *sync.Cond
+	out     bytes.Buffer
+	typed   bytes.Buffer
+	line    []byte
+	lines   []string
+	run     fakeRun
+	closed  bool
+	mirrors int
+	sizes   [][2]int
+}
+
+func newFakePty(run fakeRun) *fakePty {
+	p := &fakePty{run: run, nonce: "test"}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// echoRun runs `echo` and `exit N`; everything else is not found.
+func echoRun(lines []string) (string, uint8, bool) {
+	cmd := lines[0]
+	switch {
+	case strings.HasPrefix(cmd, "echo "):
+		return cmd[5:] + "\n", 0, true
+	case strings.HasPrefix(cmd, "exit "):
+		var code uint8
+		fmt.Sscan(cmd[5:], &code)
+		return "", code, true
+	default:
+		return "bash: " + cmd + ": command not found\n", 127, true
+	}
+}
+
+// start and prompt are the markers printed by the fake shell with the
+// "test" nonce.
+const start = "\x1B]7777;test;S;100.000000\x07"
+
+func prompt(status uint8) string {
+	return fmt.Sprintf("\x1B]7777;test;P;%d;100.250000;/home/test\x07", status)
+}
+
+func (p *fakePty) prompt(status uint8) {
+	p.out.WriteString(strings.ReplaceAll(prompt(status), "test;", p.nonce+";"))
+	p.cond.Broadcast()
+}
+
+func (p *fakePty) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.out.Len() == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if p.out.Len() == 0 {
+		return 0, io.EOF
+	}
+
+	return p.out.Read(b)
+}
+
+func (p *fakePty) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	p.typed.Write(b)
+
+	for _, c := range b {
+		if c != '\n' {
+			p.out.WriteByte(c)
+			p.line = append(p.line, c)
+			continue
+		}
+
+		p.out.WriteString("\r\n")
+		if p.lines == nil {
+			p.out.WriteString(strings.ReplaceAll(start, "test;", p.nonce+";"))
+		}
+		p.lines = append(p.lines, string(p.line))
+		p.line = p.line[:0]
+
+		output, status, done := p.run(p.lines)
+		if done {
+			p.out.WriteString(strings.ReplaceAll(output, "\n", "\r\n"))
+			p.lines = nil
+			p.prompt(status)
+		}
+	}
+
+	p.cond.Broadcast()
+	return len(b), nil
+}
+
+func (p *fakePty) Mirror() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mirrors++
+	return nil
+}
+
+func (p *fakePty) AwaitPrompt() {}
+
+func (p *fakePty) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.cond.Broadcast()
+	return nil
+}
+
+func (p *fakePty) Resize(rows, cols int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sizes = append(p.sizes, [2]int{rows, cols})
+	return nil
+}
+
+func (p *fakePty) Kill() error {
+	return p.Close()
+}
+
+func (p *fakePty) Typed() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.typed.String()
+}
+
+type fakeShell struct {
+	pty        *fakePty
+	rows, cols int
+}
+
+func (s *fakeShell) Start(rows, cols int, nonce string) (Pty, error) {
+	s.rows, s.cols = rows, cols
+
+	s.pty.mu.Lock()
+	s.pty.nonce = nonce
+	s.pty.prompt(0)
+	s.pty.mu.Unlock()
+
+	return s.pty, nil
+}
+
+// newTestSession returns a session connected to a fake shell that is
+// waiting at its prompt.
+func newTestSession(run fakeRun) (*Session, *fakePty, *fakeClock, *bytes.Buffer) {
+	var (
+		out   = &bytes.Buffer{}
+		clock = newFakeClock()
+		p     = newFakePty(run)
+	)
+
+	s := &Session{
+		ctx:    context.Background(),
+		w:      out,
+		pty:    p,
+		bash:   &BashCopy{pty: p, nonce: "test"},
+		screen: NewScreen(24, 80),
+		clock:  clock,
+	}
+	s.w = io.MultiWriter(out, s.screen)
+
+	return s, p, clock, out
+}
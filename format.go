@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format writes script in its canonical form. Parse reads the result back as
+// the same script. Comments and blank lines are kept for scripts parsed with
+// parseShallow, runs of blank lines are squeezed into one.
+func Format(script Script) string {
+	var lines []string
+	for _, op := range script {
+		lines = append(lines, formatOp(op, "")...)
+	}
+
+	var b strings.Builder
+	blank := false
+	for _, line := range lines {
+		if line == "" {
+			blank = b.Len() > 0
+			continue
+		}
+		if blank {
+			b.WriteString("\n")
+			blank = false
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+func formatOp(op Op, prefix string) []string {
+	switch x := op.(type) {
+	case *OpComment:
+		return []string{x.text}
+	case *OpEcho:
+		return []string{prefix + "SAY " + x.content}
+	case *OpType:
+		return []string{prefix + "TYPE " + formatTyped(x)}
+	case *OpBreath:
+		return []string{prefix + describe(op)}
+	case *OpRate:
+		return []string{prefix + describe(op)}
+	case *OpSnapshot:
+		return []string{prefix + "SNAPSHOT " + x.name}
+	case *OpNote:
+		return []string{prefix + "NOTE " + x.content}
+	case *OpSection:
+		return []string{prefix + "SECTION " + x.title}
+	case *OpInclude:
+		return []string{prefix + "INCLUDE " + x.path}
+	case *OpExec:
+		lines := []string{prefix + describe(op)}
+		for _, sub := range x.Ops {
+			lines = append(lines, formatOp(sub, "- ")...)
+		}
+		if x.expect != 0 {
+			lines = append(lines, "- EXPECT "+strconv.Itoa(int(x.expect)))
+		}
+		if x.narration {
+			lines = append(lines, "- NARRATION")
+		}
+		for _, c := range x.checks {
+			switch c := c.(type) {
+			case *OutputContains:
+				lines = append(lines, "- OUTPUT-CONTAINS "+escapeSequences(c.text))
+			case *OutputMatches:
+				lines = append(lines, "- OUTPUT-MATCHES "+c.pattern)
+			}
+		}
+		return lines
+	default:
+		return []string{"# " + describe(op)}
+	}
+}
+
+// formatTyped is the reverse of unescape for the content of a TYPE.
+func formatTyped(x *OpType) string {
+	var (
+		b strings.Builder
+		i int
+	)
+
+	// A < which reads as a key is escaped, and a { which reads as a mark.
+	text := func(s string) {
+		s = escapeBraces(escapeSequences(s))
+		for {
+			j := strings.IndexByte(s, '<')
+			if j < 0 {
+				break
+			}
+			if n, _ := parseKeyToken(s[j:]); n > 0 {
+				b.WriteString(s[:j] + `\<`)
+			} else {
+				b.WriteString(s[:j+1])
+			}
+			s = s[j+1:]
+		}
+		b.WriteString(s)
+	}
+
+	marks := x.marks
+	for _, k := range x.keys {
+		for len(marks) > 0 && marks[0].at <= k.at {
+			text(x.content[i:marks[0].at])
+			b.WriteString(formatMark(marks[0]))
+			i = marks[0].at
+			marks = marks[1:]
+		}
+		text(x.content[i:k.at])
+		b.WriteString("<" + k.name)
+		if k.n > 1 {
+			b.WriteString("*" + strconv.Itoa(k.n))
+		}
+		b.WriteString(">")
+		i = k.at + len(k.seq)*k.n
+	}
+	for _, mark := range marks {
+		text(x.content[i:mark.at])
+		b.WriteString(formatMark(mark))
+		i = mark.at
+	}
+	text(x.content[i:])
+
+	return b.String()
+}
+
+// escapeSequences is the reverse of replaceEscapeSequences.
+func escapeSequences(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == utf8.RuneError && !strings.HasPrefix(s[i:], "\uFFFD"):
+			fmt.Fprintf(&b, `\x%02X`, s[i])
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\x1B':
+			b.WriteString(`\e`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\v':
+			b.WriteString(`\v`)
+		case r == '\x0E' && strings.HasPrefix(s[i+1:], "H"):
+			// \SOH would read as a single character.
+			b.WriteString(`\x0E`)
+		case int(r) < len(controlChars):
+			b.WriteString(`\` + controlChars[r].name)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// formatFile rewrites a script in its canonical form. It reports whether the
+// file changed.
+func formatFile(name string) (bool, error) {
+	if isMarkdown(name) {
+		return false, fmt.Errorf("%s is Markdown, only .termp scripts can be formatted.", name)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return false, err
+	}
+
+	script, err := parseShallow(string(data))
+	if err != nil {
+		var perr *ParseError
+		if errors.As(err, &perr) {
+			perr.File = name
+		}
+		return false, err
+	}
+
+	formatted := []byte(Format(script))
+	if bytes.Equal(data, formatted) {
+		return false, nil
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(name, formatted, info.Mode())
+}
+
+func runFmt(srcs []string) {
+	failed := false
+	for _, src := range srcs {
+		changed, err := formatFile(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			failed = true
+			continue
+		}
+		if changed {
+			fmt.Println(src)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
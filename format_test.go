@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFormatRoundTrip(t *testing.T) {
+	sources := []string{
+		"SAY hi\nRUN vim\n- TYPE ihi\\e\n- BREATH\n- TYPE :x\\n\nBREATH\nSNAPSHOT end",
+		"RUN false\n- EXPECT 1\n- NARRATION\n- OUTPUT-CONTAINS a\\tb\\\\n\n- OUTPUT-MATCHES ^\\s+x$",
+		"SECTION Intro\nNOTE hello\nRUN cat\n- TYPE \\SO\\SOH\\DEL\\ESC\\NUL\n- TYPE   spaced",
+		"RUN cat\n- TYPE \x0EH",
+		"SETUP cd /tmp\n- EXPECT 2\nRATE 30\nBREATH 1.5s\nRUN vim\n- BREATH 200ms",
+		"RUN less\n- TYPE <Down*5>/x<Enter>\\<Up> <C-c>\\\\<Esc>\n- TYPE \\x80\\u{2713}\uFFFD<up\\<Esc>",
+		"RUN git com{300ms}mit{rate=8} -m \"x\" {{1s} ${HOME} {{.Id}}\n- TYPE :wq{1s}<Enter>{1.5s}{{2s}\nSETUP {rate=40}make",
+	}
+	for _, name := range []string{"example.termp", "gpg-example.termp", "dig-example.termp"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, string(data))
+	}
+
+	for _, source := range sources {
+		want, err := Parse(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := Parse(Format(want))
+		if err != nil {
+			t.Fatalf("%q: %s", Format(want), err)
+		}
+		clearPos(want)
+		clearPos(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q does not round-trip through %q", source, Format(want))
+		}
+	}
+}
+
+func TestEscapeSequencesSOH(t *testing.T) {
+	if got, want := escapeSequences("\x0EH\x0E\x01"), `\x0EH\SO\SOH`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatCanonical(t *testing.T) {
+	source := `
+
+# The intro.
+  SAY  hello
+RUN ls
+-   EXPECT 2
+- TYPE \ESC:q\LF
+
+
+# about vim
+  RUN vim
+# quit
+- TYPE :q!\n
+INCLUDE other.termp
+BREATH
+
+`
+	want := `# The intro.
+SAY  hello
+RUN ls
+- TYPE \e:q\n
+- EXPECT 2
+
+# about vim
+RUN vim
+# quit
+- TYPE :q!\n
+INCLUDE other.termp
+BREATH
+`
+
+	script, err := parseShallow(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Format(script)
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	script, err = parseShallow(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Format(script) != got {
+		t.Errorf("formatting is not stable:\n%s", Format(script))
+	}
+}
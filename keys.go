@@ -0,0 +1,161 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// keys are the keys which send escape sequences. The cursor keys send other
+// sequences when the application asked for application cursor keys (DECCKM).
+var keys = []struct {
+	name        string
+	seq, appSeq string
+}{
+	{"Up", "\x1B[A", "\x1BOA"},
+	{"Down", "\x1B[B", "\x1BOB"},
+	{"Right", "\x1B[C", "\x1BOC"},
+	{"Left", "\x1B[D", "\x1BOD"},
+	{"Home", "\x1B[H", "\x1BOH"},
+	{"End", "\x1B[F", "\x1BOF"},
+	{"Insert", "\x1B[2~", ""},
+	{"Delete", "\x1B[3~", ""},
+	{"PageUp", "\x1B[5~", ""},
+	{"PageDown", "\x1B[6~", ""},
+	{"S-Tab", "\x1B[Z", ""},
+	{"F1", "\x1BOP", ""},
+	{"F2", "\x1BOQ", ""},
+	{"F3", "\x1BOR", ""},
+	{"F4", "\x1BOS", ""},
+	{"F5", "\x1B[15~", ""},
+	{"F6", "\x1B[17~", ""},
+	{"F7", "\x1B[18~", ""},
+	{"F8", "\x1B[19~", ""},
+	{"F9", "\x1B[20~", ""},
+	{"F10", "\x1B[21~", ""},
+	{"F11", "\x1B[23~", ""},
+	{"F12", "\x1B[24~", ""},
+}
+
+// keyChars are the keys which send a single character.
+var keyChars = map[string]string{
+	"enter":     "\r",
+	"cr":        "\r",
+	"return":    "\r",
+	"tab":       "\t",
+	"esc":       "\x1B",
+	"escape":    "\x1B",
+	"bs":        "\x7F",
+	"backspace": "\x7F",
+	"space":     " ",
+}
+
+// keyAliases are other names of keys.
+var keyAliases = map[string]string{
+	"del":  "delete",
+	"pgup": "pageup",
+	"pgdn": "pagedown",
+}
+
+// typedKey is a key typed by name in a TYPE, like <Up> or <Down*5>.
+type typedKey struct {
+	name     string // as written
+	n        int    // how often it is typed
+	at       int    // where the first one is in the content of the TYPE
+	seq, app string // what it sends, app in application cursor mode
+}
+
+var keyToken = regexp.MustCompile(`^<([CcMmAa]-[^>]|[A-Za-z][A-Za-z0-9-]*)(?:\*([1-9][0-9]{0,3}))?>`)
+
+// parseKeyToken parses the named key at the start of s, like <C-c>. It
+// returns the length of the token, 0 when s does not start with one.
+func parseKeyToken(s string) (int, typedKey) {
+	m := keyToken.FindStringSubmatch(s)
+	if m == nil {
+		return 0, typedKey{}
+	}
+
+	k := typedKey{name: m[1], n: 1}
+	if m[2] != "" {
+		k.n, _ = strconv.Atoi(m[2])
+	}
+
+	var ok bool
+	k.seq, k.app, ok = keySeq(k.name)
+	if !ok {
+		return 0, typedKey{}
+	}
+	return len(m[0]), k
+}
+
+// keySeq returns what the key name sends, and what it sends in application
+// cursor mode when that is different.
+func keySeq(name string) (string, string, bool) {
+	lower := strings.ToLower(name)
+	if c, ok := keyChars[lower]; ok {
+		return c, "", true
+	}
+	if alias, ok := keyAliases[lower]; ok {
+		lower = alias
+	}
+
+	for _, k := range keys {
+		if strings.ToLower(k.name) == lower {
+			return k.seq, k.appSeq, true
+		}
+	}
+
+	if len(name) == 3 && name[1] == '-' {
+		switch lower[0] {
+		case 'c':
+			c := strings.ToUpper(name[2:])[0]
+			if c < '@' || c > '_' {
+				return "", "", false
+			}
+			return string(rune(c - '@')), "", true
+		case 'm', 'a':
+			return "\x1B" + name[2:], "", true
+		}
+	}
+
+	return "", "", false
+}
+
+// readableKeys describes the keys which type s, like ihi<Esc>:x<Enter>.
+func readableKeys(s string) string {
+	var b strings.Builder
+
+next:
+	for s != "" {
+		for _, k := range keys {
+			for _, seq := range []string{k.seq, k.appSeq} {
+				if seq != "" && strings.HasPrefix(s, seq) {
+					b.WriteString("<" + k.name + ">")
+					s = s[len(seq):]
+					continue next
+				}
+			}
+		}
+
+		r, n := utf8.DecodeRuneInString(s)
+		s = s[n:]
+
+		switch {
+		case r == '\n' || r == '\r':
+			b.WriteString("<Enter>")
+		case r == '\t':
+			b.WriteString("<Tab>")
+		case r == '\x1B':
+			b.WriteString("<Esc>")
+		case r == '\b' || r == 0x7F:
+			b.WriteString("<BS>")
+		case r < ' ':
+			b.WriteString("<C-" + strings.ToLower(string(r+'@')) + ">")
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
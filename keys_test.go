@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestReadableKeys(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"ls\n", "ls<Enter>"},
+		{"\x1B:wq\r", "<Esc>:wq<Enter>"},
+		{"\x1B[A\x1BOB\x1B[15~", "<Up><Down><F5>"},
+		{"\x03\x7F\t\x1B[Z", "<C-c><BS><Tab><S-Tab>"},
+	}
+
+	for _, test := range tests {
+		if got := readableKeys(test.in); got != test.want {
+			t.Errorf("readableKeys(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestTypedKeys(t *testing.T) {
+	op, err := parseSubLine(`TYPE <Down*2>x<C-c><m-x><Tab>\<Up><Nope><Home>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := op.(*OpType)
+
+	if want := "\x1B[B\x1B[Bx\x03\x1Bx\t<Up><Nope>\x1B[H"; x.content != want {
+		t.Errorf("got %q, want %q", x.content, want)
+	}
+	if got, want := x.typed(true), "\x1BOB\x1BOBx\x03\x1Bx\t<Up><Nope>\x1BOH"; got != want {
+		t.Errorf("got %q, want %q in application cursor mode", got, want)
+	}
+	if got, want := formatTyped(x), `<Down*2>x<C-c><m-x><Tab>\<Up><Nope><Home>`; got != want {
+		t.Errorf("formatted as %q, want %q", got, want)
+	}
+}
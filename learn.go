@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/creack/pty"
+)
+
+// learnPause is the shortest pause between keys which becomes a BREATH.
+const learnPause = breathPause
+
+// learnedBreath returns the BREATH for a pause between keys, nil when the
+// pause is too short. For the first key typed into a command the pause is
+// taken from when the command started; a RUN already waits subOpsPause
+// before it types into its command, so only the rest needs a BREATH.
+func learnedBreath(pause time.Duration, first bool) *OpBreath {
+	if first {
+		pause -= subOpsPause
+	}
+	if pause < learnPause {
+		return nil
+	}
+	d := pause.Round(100 * time.Millisecond)
+	if d == breathPause {
+		d = 0
+	}
+	return &OpBreath{d: d}
+}
+
+// Learner turns a session typed by hand into a script. Commands come from
+// the markers of the shell, the keys typed while a command runs are typed
+// into it by the script.
+type Learner struct {
+	mu     sync.Mutex
+	script Script
+	run    *OpExec   // the running command
+	typed  string    // typed into it, but not added as a TYPE yet
+	last   time.Time // when the last key was typed into it
+}
+
+// Start is called when the shell starts cmd.
+func (l *Learner) Start(cmd string, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.run = &OpExec{cmd: cmd}
+	l.typed = ""
+	l.last = at
+}
+
+// Key is called with the keys typed into the terminal.
+func (l *Learner) Key(keys []byte, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The keys typed at the prompt are in the command line.
+	if l.run == nil {
+		return
+	}
+
+	first := len(l.run.Ops) == 0 && l.typed == ""
+	if breath := learnedBreath(at.Sub(l.last), first); breath != nil {
+		l.flush()
+		l.run.Ops = append(l.run.Ops, breath)
+	}
+	// Enter sends CR, scripts type a newline like the tapes of VHS do.
+	l.typed += strings.ReplaceAll(string(keys), "\r", "\n")
+	l.last = at
+}
+
+// Prompt is called when the shell prompts again, after a command which
+// exited with status.
+func (l *Learner) Prompt(status uint8) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.run == nil {
+		return
+	}
+	l.flush()
+	l.run.expect = status
+	l.script = append(l.script, l.run)
+	l.run = nil
+}
+
+func (l *Learner) flush() {
+	if l.typed != "" {
+		l.run.Ops = append(l.run.Ops, &OpType{content: l.typed})
+		l.typed = ""
+	}
+}
+
+// Script returns the commands which completed so far.
+func (l *Learner) Script() Script {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append(Script(nil), l.script...)
+}
+
+// Learn runs an instrumented shell on the terminal in until it exits and
+// returns what was done in it as a script. The terminal is put into raw
+// mode, all keys go to the shell.
+func Learn(ctx context.Context, in *os.File, out io.Writer) (Script, error) {
+	rows, cols, err := pty.Getsize(in)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	shell := &BashShell{Vars: learnVars(nonce)}
+	p, err := shell.Start(rows, cols, nonce)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+
+	state, err := makeRaw(in)
+	if err != nil {
+		p.Kill()
+		return nil, err
+	}
+	defer state.Restore()
+
+	l := &Learner{}
+	b := &BashCopy{pty: p, nonce: nonce, onMarker: func(m marker) {
+		switch m.kind {
+		case 'S':
+			l.Start(m.cmd, time.Now())
+		case 'P':
+			l.Prompt(m.status)
+		}
+	}}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Kill()
+		case <-done:
+		}
+	}()
+
+	go func() {
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		defer signal.Stop(winch)
+		for {
+			select {
+			case <-done:
+				return
+			case <-winch:
+			}
+			if rows, cols, err := pty.Getsize(in); err == nil {
+				p.Resize(rows, cols)
+			}
+		}
+	}()
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := in.Read(buf)
+			if n > 0 {
+				l.Key(buf[:n], time.Now())
+				p.Write(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// The shell is gone when its output ends.
+	for err == nil {
+		err = b.Copy(out)
+	}
+
+	return l.Script(), nil
+}
+
+// makeRaw puts the terminal f into raw mode, the returned state restores it.
+func makeRaw(f *os.File) (*PtyState, error) {
+	state, err := newPtyState(f)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := state.oldState
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL,
+		f.Fd(),
+		ioctlWriteTermios,
+		uintptr(unsafe.Pointer(&raw)),
+		0, 0, 0); err != 0 {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// runLearn records a session into the script name, which must not exist
+// yet.
+func runLearn(ctx context.Context, name string) {
+	if _, err := os.Stat(name); err == nil {
+		fmt.Fprintf(os.Stderr, "error: %s already exists.\n", name)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Learning %s, exit the shell when the demo is done.\n", name)
+	script, err := Learn(ctx, os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+
+	header := &OpComment{text: "# Learned on " + time.Now().Format("2006-01-02") + ", a first draft."}
+	err = os.WriteFile(name, []byte(Format(append(Script{header}, script...))), 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Learned %d commands.\n", len(script))
+}
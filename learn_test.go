@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLearner(t *testing.T) {
+	var (
+		l  Learner
+		t0 = time.Unix(100, 0)
+		at = func(d time.Duration) time.Time { return t0.Add(d) }
+	)
+
+	// Typed at the prompt, part of the command line.
+	l.Key([]byte("ls\r"), at(0))
+	l.Start("ls", at(time.Second))
+	l.Prompt(0)
+
+	l.Start("vim x", at(2*time.Second))
+	l.Key([]byte("i"), at(2600*time.Millisecond))
+	l.Key([]byte("hi"), at(2900*time.Millisecond))
+	l.Key([]byte("\x1B"), at(3900*time.Millisecond))
+	l.Key([]byte(":x\r"), at(7*time.Second))
+	l.Prompt(0)
+
+	l.Start("false", at(8*time.Second))
+	l.Prompt(1)
+
+	// The shell exits before the prompt.
+	l.Start("exit", at(9*time.Second))
+
+	got := Format(l.Script())
+	want := `RUN ls
+RUN vim x
+- TYPE ihi
+- BREATH
+- TYPE \e
+- BREATH 3.1s
+- TYPE :x\n
+RUN false
+- EXPECT 1
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/creack/pty"
+)
+
+// Warning is a problem found by the Linter.
+type Warning struct {
+	Pos
+	Msg string
+}
+
+// Linter finds things in scripts which are likely to go wrong on stage.
+type Linter struct {
+	Cols     int                               // the width of the terminal
+	LookPath func(file string) (string, error) // defaults to exec.LookPath
+}
+
+var (
+	destructiveCommand = regexp.MustCompile(`(^|[;&|(]|\s)(sudo\s+)?rm\s+(-\w*[rR]|--recursive)`)
+	secretText         = regexp.MustCompile(`(?i)pass(word|phrase)|secret|token|api.?key|\b(ghp_|sk-|AKIA)\w{8,}`)
+	assignment         = regexp.MustCompile(`^\w+=`)
+)
+
+// secretPrompts are commands which read a password from the terminal.
+var secretPrompts = map[string]bool{
+	"gpg": true, "sudo": true, "su": true, "passwd": true,
+	"ssh-keygen": true, "htpasswd": true,
+}
+
+var shellBuiltins = map[string]bool{}
+
+func init() {
+	for _, name := range strings.Fields(`! . : [ [[ { alias bg bind break builtin
+		caller case cd command compgen complete compopt continue coproc declare
+		dirs disown echo enable eval exec exit export false fc fg for function
+		getopts hash help history if jobs kill let local logout mapfile popd
+		printf pushd pwd read readarray readonly return select set shift shopt
+		source suspend test time times trap true type typeset ulimit umask
+		unalias unset until wait while`) {
+		shellBuiltins[name] = true
+	}
+}
+
+// commandName returns the command a RUN starts, or "" when it can not tell.
+func commandName(cmd string) string {
+	for _, word := range strings.Fields(cmd) {
+		if assignment.MatchString(word) {
+			continue
+		}
+		if strings.ContainsAny(word, "/$`\"'()<>|&;") {
+			return ""
+		}
+		return word
+	}
+	return ""
+}
+
+func (l *Linter) Lint(script Script) []Warning {
+	var (
+		warnings []Warning
+		lookPath = l.LookPath
+		cols     = l.Cols
+	)
+	if lookPath == nil {
+		lookPath = exec.LookPath
+	}
+	if cols == 0 {
+		cols = 80
+	}
+
+	warn := func(op Op, format string, args ...any) {
+		warnings = append(warnings, Warning{Pos: *opPos(op), Msg: fmt.Sprintf(format, args...)})
+	}
+
+	for _, op := range script {
+		switch x := op.(type) {
+		case *OpEcho:
+			width := utf8.RuneCountInString("# " + x.content)
+			if width > cols {
+				warn(op, "SAY is %d columns wide, the terminal only %d.", width, cols)
+			}
+
+		case *OpExec:
+			if destructiveCommand.MatchString(x.cmd) {
+				warn(op, "RUN deletes files recursively, make sure it can not hit anything else.")
+			}
+
+			name := commandName(x.cmd)
+			if name != "" && !shellBuiltins[name] && x.expect != 127 {
+				_, err := lookPath(name)
+				if err != nil {
+					warn(op, "%s is not on the PATH.", name)
+				}
+			}
+
+			var last *OpType
+			for _, sub := range x.Ops {
+				t, ok := sub.(*OpType)
+				if !ok {
+					continue
+				}
+				last = t
+				if secretPrompts[name] || secretText.MatchString(t.content) {
+					warn(sub, "TYPE looks like a secret, it ends up in the script and in recordings.")
+				}
+			}
+			if last != nil && !strings.HasSuffix(last.content, "\n") && !strings.HasSuffix(last.content, "\r") {
+				warn(last, "the last TYPE does not end in a newline, the command may still be waiting for it when the next op runs.")
+			}
+		}
+	}
+	return warnings
+}
+
+// terminalCols returns the width of the terminal, 0 when it is unknown.
+func terminalCols() int {
+	_, cols, err := pty.Getsize(os.Stdout)
+	if err != nil {
+		return 0
+	}
+	return cols
+}
+
+// writeWarnings writes warnings as file:line: message.
+func writeWarnings(w io.Writer, name string, warnings []Warning) {
+	for _, warning := range warnings {
+		file := warning.File
+		if file == "" {
+			file = name
+		}
+		fmt.Fprintf(w, "%s:%d: %s\n", file, warning.Line, warning.Msg)
+	}
+}
+
+func runLint(srcs []string) {
+	var (
+		l      = &Linter{Cols: terminalCols()}
+		failed = false
+	)
+	for _, src := range srcs {
+		script, err := ParseFile(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			failed = true
+			continue
+		}
+
+		warnings := l.Lint(script)
+		writeWarnings(os.Stdout, src, warnings)
+		if len(warnings) > 0 {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	script, err := Parse(`SAY short
+SAY this one is too long for a tiny terminal
+RUN rm -rf build
+RUN cd /tmp && rm -r x
+RUN rm -f x
+RUN FOO=1 missing-tool --flag
+RUN missing-tool
+- EXPECT 127
+RUN ./local.sh
+RUN gpg -c x
+- TYPE hunter2\n
+RUN mysql
+- TYPE my password is x\n
+RUN vim
+- TYPE ihi\e
+- BREATH
+RUN less x
+- TYPE \n
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := &Linter{
+		Cols: 30,
+		LookPath: func(file string) (string, error) {
+			if file == "missing-tool" {
+				return "", errors.New("not found")
+			}
+			return "/bin/" + file, nil
+		},
+	}
+
+	var out bytes.Buffer
+	writeWarnings(&out, "x.termp", l.Lint(script))
+
+	want := `x.termp:2: SAY is 42 columns wide, the terminal only 30.
+x.termp:3: RUN deletes files recursively, make sure it can not hit anything else.
+x.termp:4: RUN deletes files recursively, make sure it can not hit anything else.
+x.termp:6: missing-tool is not on the PATH.
+x.termp:11: TYPE looks like a secret, it ends up in the script and in recordings.
+x.termp:13: TYPE looks like a secret, it ends up in the script and in recordings.
+x.termp:15: the last TYPE does not end in a newline, the command may still be waiting for it when the next op runs.
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestCommandName(t *testing.T) {
+	tests := map[string]string{
+		"ls -l":             "ls",
+		"A=1 B=2 make test": "make",
+		"./run.sh":          "",
+		"$EDITOR x":         "",
+		"cd ..":             "cd",
+	}
+	for cmd, want := range tests {
+		if got := commandName(cmd); got != want {
+			t.Errorf("commandName(%q) = %q, want %q", cmd, got, want)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Just enough of the Language Server Protocol for editing scripts: parse
+// errors and lint warnings, completion of directives and escapes, hovers
+// with the bytes a TYPE sends, SECTIONs as symbols and INCLUDEs as links.
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSON-RPC error codes.
+const (
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+// maxRPC is the largest message the server reads.
+const maxRPC = 64 << 20
+
+func readRPC(r *bufio.Reader) (*rpcMessage, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, errors.New("invalid Content-Length.")
+	}
+	if n > maxRPC {
+		return nil, fmt.Errorf("a message of %d bytes is too large.", n)
+	}
+
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg rpcMessage
+	err = json.Unmarshal(body, &msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func writeRPC(w io.Writer, msg *rpcMessage) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+// Diagnostic severities.
+const (
+	lspError   = 1
+	lspWarning = 2
+)
+
+type lspCompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Completion item kinds.
+const (
+	lspKeyword  = 14
+	lspConstant = 21
+)
+
+type lspHover struct {
+	Contents struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	} `json:"contents"`
+	Range lspRange `json:"range"`
+}
+
+type lspSymbol struct {
+	Name           string   `json:"name"`
+	Kind           int      `json:"kind"`
+	Range          lspRange `json:"range"`
+	SelectionRange lspRange `json:"selectionRange"`
+}
+
+// lspNamespace is the symbol kind of sections.
+const lspNamespace = 3
+
+type lspTextDocument struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	Position       lspPosition `json:"position"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+var directives = []struct{ name, desc string }{
+	{"SAY", "Types a comment for the audience."},
+	{"RUN", "Types a command and runs it, {300ms} pauses and {rate=8} changes the typing rate."},
+	{"SETUP", "Runs a command without showing it to the audience."},
+	{"BREATH", "Pauses for a second, or as long as given."},
+	{"RATE", "Sets how many characters per second are typed from here on."},
+	{"NOTE", "A note for the presenter, never shown to the audience."},
+	{"SECTION", "Starts a section, the target of --from, --to and jump."},
+	{"SNAPSHOT", "Compares the screen with its golden file with --check-snapshots, or updates it with --update-snapshots."},
+	{"INCLUDE", "Includes another script, relative to this one."},
+}
+
+var subDirectives = []struct{ name, desc string }{
+	{"TYPE", "Types into the running command, escapes like \\e, keys like <Up> and pauses like {300ms} are allowed."},
+	{"BREATH", "Pauses for a second, or as long as given."},
+	{"SNAPSHOT", "Compares the screen with its golden file with --check-snapshots, or updates it with --update-snapshots."},
+	{"EXPECT", "The exit status the command should have."},
+	{"NARRATION", "The command does not change the shell, --from skips it."},
+	{"OUTPUT-CONTAINS", "Fails unless the output contains this text."},
+	{"OUTPUT-MATCHES", "Fails unless a line of the output matches this pattern."},
+}
+
+// LSPServer serves the Language Server Protocol for scripts.
+type LSPServer struct {
+	w    io.Writer
+	docs map[string]string
+}
+
+func NewLSPServer() *LSPServer {
+	return &LSPServer{docs: map[string]string{}}
+}
+
+// Serve handles messages from r until the client exits.
+func (s *LSPServer) Serve(r io.Reader, w io.Writer) error {
+	s.w = w
+	br := bufio.NewReader(r)
+
+	for {
+		msg, err := readRPC(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			return nil
+		}
+
+		result, rerr := s.handle(msg)
+		if msg.ID == nil {
+			continue
+		}
+
+		reply := &rpcMessage{ID: msg.ID, Error: rerr}
+		if rerr == nil {
+			reply.Result, err = json.Marshal(result)
+			if err != nil {
+				return err
+			}
+		}
+		err = writeRPC(w, reply)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *LSPServer) handle(msg *rpcMessage) (any, *rpcError) {
+	var params lspTextDocument
+	if len(msg.Params) > 0 && json.Unmarshal(msg.Params, &params) != nil {
+		return nil, &rpcError{rpcInvalidParams, "invalid params."}
+	}
+	uri := params.TextDocument.URI
+
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":       1, // full
+				"completionProvider":     map[string]any{"triggerCharacters": []string{"\\"}},
+				"hoverProvider":          true,
+				"documentSymbolProvider": true,
+				"definitionProvider":     true,
+			},
+			"serverInfo": map[string]string{"name": "term-present"},
+		}, nil
+
+	case "initialized", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+
+	case "shutdown":
+		return nil, nil
+
+	case "textDocument/didOpen":
+		s.docs[uri] = params.TextDocument.Text
+		s.publish(uri)
+		return nil, nil
+
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[uri] = params.ContentChanges[n-1].Text
+		}
+		s.publish(uri)
+		return nil, nil
+
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		return nil, nil
+
+	case "textDocument/completion":
+		return s.complete(uri, params.Position), nil
+
+	case "textDocument/hover":
+		return s.hover(uri, params.Position), nil
+
+	case "textDocument/documentSymbol":
+		return s.symbols(uri), nil
+
+	case "textDocument/definition":
+		return s.definition(uri, params.Position), nil
+	}
+
+	if msg.ID == nil {
+		return nil, nil
+	}
+	return nil, &rpcError{rpcMethodNotFound, "unknown method " + msg.Method + "."}
+}
+
+func (s *LSPServer) line(uri string, n int) string {
+	lines := strings.Split(s.docs[uri], "\n")
+	if n < 0 || n >= len(lines) {
+		return ""
+	}
+	return strings.TrimSuffix(lines[n], "\r")
+}
+
+// lineRange is the range of line n.
+func lineRange(n int, line string) lspRange {
+	return lspRange{lspPosition{n, 0}, lspPosition{n, utf16Len(line)}}
+}
+
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// byteOffset converts an offset in UTF-16 code units into one in bytes.
+func byteOffset(s string, char int) int {
+	for i, r := range s {
+		if char <= 0 {
+			return i
+		}
+		char -= len(utf16.Encode([]rune{r}))
+	}
+	return len(s)
+}
+
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return u.Path
+}
+
+func pathURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// diagnose parses a document along with the files it includes and lints it.
+func (s *LSPServer) diagnose(uri string) []lspDiagnostic {
+	diags := []lspDiagnostic{}
+	add := func(line, severity int, msg string) {
+		diags = append(diags, lspDiagnostic{lineRange(line, s.line(uri, line)), severity, "term-present", msg})
+	}
+
+	// The parser reads Markdown by the name, the same way ParseFile does.
+	p := &parser{shallow: true}
+	script, err := p.parse(s.docs[uri], uriPath(uri), false, ".")
+	var perr *ParseError
+	if errors.As(err, &perr) {
+		add(perr.Line-1, lspError, perr.Err.Error())
+		return diags
+	}
+
+	dir := filepath.Dir(uriPath(uri))
+	for _, op := range script {
+		x, ok := op.(*OpInclude)
+		if !ok {
+			continue
+		}
+		path := x.path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		_, err := ParseFile(path)
+		if err != nil {
+			add(x.Line-1, lspError, err.Error())
+		}
+	}
+
+	for _, w := range (&Linter{}).Lint(script) {
+		add(w.Line-1, lspWarning, w.Msg)
+	}
+	return diags
+}
+
+func (s *LSPServer) publish(uri string) {
+	params, _ := json.Marshal(map[string]any{"uri": uri, "diagnostics": s.diagnose(uri)})
+	writeRPC(s.w, &rpcMessage{Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+func (s *LSPServer) complete(uri string, pos lspPosition) []lspCompletionItem {
+	line := s.line(uri, pos.Line)
+	before := line[:byteOffset(line, pos.Character)]
+	trimmed := strings.TrimLeft(before, " \t")
+	items := []lspCompletionItem{}
+
+	sub := strings.HasPrefix(trimmed, "- ")
+	if sub {
+		trimmed = strings.TrimLeft(trimmed[2:], " \t")
+	}
+
+	if !strings.Contains(trimmed, " ") {
+		list := directives
+		if sub {
+			list = subDirectives
+		}
+		for _, d := range list {
+			items = append(items, lspCompletionItem{d.name, lspKeyword, d.desc})
+		}
+		return items
+	}
+
+	typed := sub && strings.HasPrefix(trimmed, "TYPE ")
+	if i := strings.LastIndexByte(before, '<'); typed && i >= 0 && !strings.ContainsAny(before[i+1:], " <>") {
+		for _, k := range keys {
+			items = append(items, lspCompletionItem{k.name, lspConstant, visible(k.seq)})
+		}
+		for _, name := range []string{"Enter", "Tab", "Esc", "BS", "Space"} {
+			items = append(items, lspCompletionItem{name, lspConstant, visible(keyChars[strings.ToLower(name)])})
+		}
+		return items
+	}
+
+	escapes := typed || sub && strings.HasPrefix(trimmed, "OUTPUT-CONTAINS ")
+	i := strings.LastIndexByte(before, '\\')
+	if !escapes || i < 0 || strings.ContainsAny(before[i+1:], " \\") {
+		return items
+	}
+
+	for _, e := range []struct{ name, desc string }{
+		{"e", "Escape"}, {"n", "Line Feed"}, {"t", "Horizontal Tab"}, {"v", "Vertical Tab"}, {"\\", "Backslash"},
+		{"x", "A byte in hex, like \\x7F"}, {"u{", "A Unicode code point in hex, like \\u{2713}"},
+	} {
+		items = append(items, lspCompletionItem{e.name, lspConstant, e.desc})
+	}
+	for c, char := range controlChars {
+		items = append(items, lspCompletionItem{char.name, lspConstant, fmt.Sprintf("%s (0x%02X)", char.desc, c)})
+	}
+	return items
+}
+
+func (s *LSPServer) hover(uri string, pos lspPosition) *lspHover {
+	line := s.line(uri, pos.Line)
+	text := strings.TrimSpace(line)
+	if !strings.HasPrefix(text, "- ") {
+		return nil
+	}
+	text = strings.TrimSpace(text[2:])
+
+	var decoded string
+	switch {
+	case strings.HasPrefix(text, "TYPE "):
+		decoded = parseTyped(text[5:]).content
+	case strings.HasPrefix(text, "OUTPUT-CONTAINS "):
+		decoded = replaceEscapeSequences(text[16:])
+	default:
+		return nil
+	}
+
+	var hex []string
+	for _, b := range []byte(decoded) {
+		hex = append(hex, fmt.Sprintf("%02x", b))
+	}
+
+	h := &lspHover{Range: lineRange(pos.Line, line)}
+	h.Contents.Kind = "markdown"
+	h.Contents.Value = fmt.Sprintf("```\n%s\n```\n%d bytes: `%s`", visible(decoded), len(decoded), strings.Join(hex, " "))
+	return h
+}
+
+// symbols returns the SECTIONs of a document, each up to the next one.
+func (s *LSPServer) symbols(uri string) []lspSymbol {
+	symbols := []lspSymbol{}
+	lines := strings.Split(s.docs[uri], "\n")
+
+	for n, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		text := strings.TrimSpace(line)
+		if !strings.HasPrefix(text, "SECTION ") || len(text) <= 8 {
+			continue
+		}
+		if len(symbols) > 0 {
+			last := &symbols[len(symbols)-1]
+			last.Range.End = lspPosition{n, 0}
+		}
+		symbols = append(symbols, lspSymbol{
+			Name:           strings.TrimSpace(text[8:]),
+			Kind:           lspNamespace,
+			Range:          lineRange(n, line),
+			SelectionRange: lineRange(n, line),
+		})
+	}
+
+	if len(symbols) > 0 {
+		last := &symbols[len(symbols)-1]
+		n := len(lines) - 1
+		last.Range.End = lspPosition{n, utf16Len(lines[n])}
+	}
+	return symbols
+}
+
+// definition returns the file an INCLUDE refers to.
+func (s *LSPServer) definition(uri string, pos lspPosition) *lspLocation {
+	text := strings.TrimSpace(s.line(uri, pos.Line))
+	if !strings.HasPrefix(text, "INCLUDE ") || len(text) <= 8 {
+		return nil
+	}
+
+	path := strings.TrimSpace(text[8:])
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(uriPath(uri)), path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	return &lspLocation{URI: pathURI(path)}
+}
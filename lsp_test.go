@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lspClient talks to an LSPServer running in the same process.
+type lspClient struct {
+	t     *testing.T
+	w     io.WriteCloser
+	msgs  chan *rpcMessage
+	id    int
+	diags map[string][]lspDiagnostic
+	done  chan error
+}
+
+func newLSPClient(t *testing.T) *lspClient {
+	var (
+		inR, inW   = io.Pipe()
+		outR, outW = io.Pipe()
+		c          = &lspClient{t: t, w: inW, msgs: make(chan *rpcMessage, 16), diags: map[string][]lspDiagnostic{}, done: make(chan error, 1)}
+	)
+
+	go func() {
+		c.done <- NewLSPServer().Serve(inR, outW)
+		outW.Close()
+	}()
+
+	// The pipes are unbuffered, so the server can publish diagnostics while
+	// the client is writing a request.
+	go func() {
+		defer close(c.msgs)
+		r := bufio.NewReader(outR)
+		for {
+			msg, err := readRPC(r)
+			if err != nil {
+				return
+			}
+			c.msgs <- msg
+		}
+	}()
+
+	return c
+}
+
+func (c *lspClient) notify(method string, params any) {
+	data, _ := json.Marshal(params)
+	err := writeRPC(c.w, &rpcMessage{Method: method, Params: data})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// call sends a request and decodes its result into result, collecting the
+// diagnostics published in the meantime.
+func (c *lspClient) call(method string, params, result any) {
+	c.id++
+	id, _ := json.Marshal(c.id)
+	data, _ := json.Marshal(params)
+	err := writeRPC(c.w, &rpcMessage{ID: id, Method: method, Params: data})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	for msg := range c.msgs {
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var p struct {
+				URI         string          `json:"uri"`
+				Diagnostics []lspDiagnostic `json:"diagnostics"`
+			}
+			json.Unmarshal(msg.Params, &p)
+			c.diags[p.URI] = p.Diagnostics
+			continue
+		}
+
+		if string(msg.ID) != string(id) {
+			c.t.Fatalf("unexpected message %+v", msg)
+		}
+		if msg.Error != nil {
+			c.t.Fatalf("%s: %s", method, msg.Error.Message)
+		}
+		if result != nil {
+			err = json.Unmarshal(msg.Result, result)
+			if err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return
+	}
+	c.t.Fatalf("%s: the server went away", method)
+}
+
+func (c *lspClient) open(uri, text string) {
+	c.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "termp", "version": 1, "text": text},
+	})
+}
+
+func at(uri string, line, char int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": line, "character": char},
+	}
+}
+
+func TestLSP(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "part.termp"), []byte("SAY included\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "broken.termp"), []byte("DANCE\n"), 0644)
+
+	uri := pathURI(filepath.Join(dir, "main.termp"))
+	text := "SECTION Intro\nSAY hi\nRUN cat\n- TYPE ihi\\e:x\\n\nINCLUDE part.termp\nSECTION Outro\nINCLUDE broken.termp\nRUN rm -r /tmp/x\n"
+
+	c := newLSPClient(t)
+
+	var init struct {
+		Capabilities map[string]any `json:"capabilities"`
+	}
+	c.call("initialize", map[string]any{"capabilities": map[string]any{}}, &init)
+	if init.Capabilities["hoverProvider"] != true {
+		t.Errorf("unexpected capabilities %v", init.Capabilities)
+	}
+	c.notify("initialized", map[string]any{})
+
+	c.open(uri, text)
+
+	var symbols []lspSymbol
+	c.call("textDocument/documentSymbol", at(uri, 0, 0), &symbols)
+	if len(symbols) != 2 || symbols[0].Name != "Intro" || symbols[0].Range.End.Line != 5 || symbols[1].Name != "Outro" || symbols[1].Range.End.Line != 8 {
+		t.Errorf("unexpected symbols %+v", symbols)
+	}
+
+	diags := c.diags[uri]
+	if len(diags) != 2 {
+		t.Fatalf("unexpected diagnostics %+v", diags)
+	}
+	if diags[0].Range.Start.Line != 6 || diags[0].Severity != lspError || !strings.HasSuffix(diags[0].Message, "broken.termp:1: unable to interpret the script.") {
+		t.Errorf("unexpected diagnostic %+v", diags[0])
+	}
+	if diags[1].Range.Start.Line != 7 || diags[1].Severity != lspWarning {
+		t.Errorf("unexpected diagnostic %+v", diags[1])
+	}
+
+	var hover lspHover
+	c.call("textDocument/hover", at(uri, 3, 3), &hover)
+	want := "```\nihi<ESC>:x<LF>\n```\n7 bytes: `69 68 69 1b 3a 78 0a`"
+	if hover.Contents.Value != want {
+		t.Errorf("unexpected hover %q", hover.Contents.Value)
+	}
+
+	var loc *lspLocation
+	c.call("textDocument/definition", at(uri, 4, 3), &loc)
+	if loc == nil || loc.URI != pathURI(filepath.Join(dir, "part.termp")) {
+		t.Errorf("unexpected definition %+v", loc)
+	}
+	loc = nil
+	c.call("textDocument/definition", at(uri, 1, 3), &loc)
+	if loc != nil {
+		t.Errorf("unexpected definition %+v", loc)
+	}
+
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []map[string]any{{"text": "SAY ok\nRUN ls\n- TY\n- TYPE a\\E\n- TYPE a<Do\n"}},
+	})
+
+	labels := func(items []lspCompletionItem) string {
+		var names []string
+		for _, item := range items {
+			names = append(names, item.Label)
+		}
+		return strings.Join(names, " ")
+	}
+
+	var items []lspCompletionItem
+	c.call("textDocument/completion", at(uri, 0, 1), &items)
+	if labels(items) != "SAY RUN SETUP BREATH RATE NOTE SECTION SNAPSHOT INCLUDE" {
+		t.Errorf("unexpected completion %q", labels(items))
+	}
+	c.call("textDocument/completion", at(uri, 2, 4), &items)
+	if !strings.HasPrefix(labels(items), "TYPE BREATH SNAPSHOT EXPECT") {
+		t.Errorf("unexpected completion %q", labels(items))
+	}
+	c.call("textDocument/completion", at(uri, 3, 10), &items)
+	if !strings.Contains(labels(items), "ESC") || len(items) != 7+len(controlChars) {
+		t.Errorf("unexpected completion %q", labels(items))
+	}
+	c.call("textDocument/completion", at(uri, 4, 11), &items)
+	if !strings.HasPrefix(labels(items), "Up Down") || len(items) != 5+len(keys) {
+		t.Errorf("unexpected completion %q", labels(items))
+	}
+	c.call("textDocument/completion", at(uri, 0, 6), &items)
+	if len(items) != 0 {
+		t.Errorf("unexpected completion %q", labels(items))
+	}
+
+	diags = c.diags[uri]
+	if len(diags) != 1 || diags[0].Range.Start.Line != 2 || diags[0].Message != "unable to interpret the script." {
+		t.Errorf("unexpected diagnostics %+v", diags)
+	}
+
+	md := pathURI(filepath.Join(dir, "README.md"))
+	c.open(md, "Intro\n=====\n\nSome prose.\n<!-- TYPE x -->\n```\n$ ls\n```\n")
+	c.call("textDocument/documentSymbol", at(md, 0, 0), &symbols)
+	diags = c.diags[md]
+	if len(diags) != 1 || diags[0].Range.Start.Line != 4 || diags[0].Message != "unable to interpret the script." {
+		t.Errorf("unexpected diagnostics %+v", diags)
+	}
+
+	c.call("shutdown", nil, nil)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadRPCLength(t *testing.T) {
+	for _, length := range []string{"-1", "x", "99999999999"} {
+		r := bufio.NewReader(strings.NewReader("Content-Length: " + length + "\r\n\r\n{}"))
+		_, err := readRPC(r)
+		if err == nil {
+			t.Errorf("Content-Length %s: expected an error", length)
+		}
+	}
+
+	r := bufio.NewReader(strings.NewReader("Content-Length: 2\r\n\r\n{}"))
+	if _, err := readRPC(r); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
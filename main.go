@@ -1,52 +1,335 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/creack/pty"
 	"github.com/docopt/docopt-go"
 )
 
 const usage = `Terminal presenter.
 
 Usage:
-  term-present [--upload] <src>
+  term-present lsp
+  term-present [options] <src>
+  term-present test [--junit=<file>] [--snapshots=<dir>] [--update-snapshots] <src>...
+  term-present ctl <addr> <command> [<arg>]
+  term-present watch [--run] [--fast] [--snapshots=<dir>] [--check-snapshots | --update-snapshots] <src>
+  term-present fmt <src>...
+  term-present lint <src>...
+  term-present learn <file>
+  term-present import tape <file>
+  term-present import cast [--prompt=<regex>] <file>
+  term-present export (--tape | --sh) <src>
   term-present -h | --help
   term-present --version
 
 Options:
-  -h --help     Show this screen.
-  --version     Show version.
-  -u --upload   Upload this session to asciinema.org.
+  -h --help              Show this screen.
+  --version              Show version.
+  -u --upload            Upload this session to asciinema.org.
+  --record=<file>        Save this session as an asciicast v2 file.
+  --snapshots=<dir>      Directory for SNAPSHOT files, relative to the script
+                         [default: snapshots].
+  --check-snapshots      Compare SNAPSHOTs against the files in the snapshot
+                         directory. The script then runs on a 24x80 screen.
+  --update-snapshots     Write SNAPSHOTs into the snapshot directory, on a
+                         24x80 screen.
+  --junit=<file>         Write the test results as JUnit XML.
+  --summary              Print a summary of the run when the script ends.
+  --events=<sink>        Write JSON lines describing the run to a file, fd:<n>
+                         or unix:<socket>.
+  --notes-server=<addr>  Serve presenter notes over HTTP on this address.
+  --control=<addr>       Accept control commands over HTTP on this address
+                         or on unix:<socket>.
+  --step                 Wait for a next command before every op.
+  --broadcast=<addr>     Stream the session to browsers on this address.
+  --from=<at>            Start at a line or section, the commands before it
+                         are replayed without being shown.
+  --to=<at>              Stop after a line or section.
+  --run                  Run the script after every change, from the section
+                         which changed.
+  --fast                 Type and pause without any delays.
+  --dry-run              Print what the script would do and how long it would
+                         take, without running anything.
+  --tape                 Export as a VHS tape.
+  --sh                   Export as a bash script.
+  --prompt=<regex>       Take the commands of a recording from the lines of
+                         its output which match, instead of what was typed.
+
+Control commands:
+  status                 Show the state of the script.
+  next                   Run the next op, even when paused.
+  pause, resume          Pause before the next op or continue.
+  speed <factor>         Change the pace, 2 runs twice as fast.
+  jump <section>         Continue at a section, by title or number.
 `
 
 func main() {
 	var (
-		args, _   = docopt.ParseDoc(usage)
-		src, _    = args["<src>"].(string)
-		upload, _ = args["--upload"].(bool)
+		args, _      = docopt.ParseDoc(usage)
+		srcs, _      = args["<src>"].([]string)
+		upload, _    = args["--upload"].(bool)
+		record, _    = args["--record"].(string)
+		snaps, _     = args["--snapshots"].(string)
+		check, _     = args["--check-snapshots"].(bool)
+		update, _    = args["--update-snapshots"].(bool)
+		test, _      = args["test"].(bool)
+		junit, _     = args["--junit"].(string)
+		summary, _   = args["--summary"].(bool)
+		events, _    = args["--events"].(string)
+		notes, _     = args["--notes-server"].(string)
+		control, _   = args["--control"].(string)
+		step, _      = args["--step"].(bool)
+		ctl, _       = args["ctl"].(bool)
+		broadcast, _ = args["--broadcast"].(string)
+		from, _      = args["--from"].(string)
+		to, _        = args["--to"].(string)
+		watch, _     = args["watch"].(bool)
+		dryRun, _    = args["--dry-run"].(bool)
+		format, _    = args["fmt"].(bool)
+		lint, _      = args["lint"].(bool)
+		lsp, _       = args["lsp"].(bool)
+		imp, _       = args["import"].(bool)
+		export, _    = args["export"].(bool)
+		learn, _     = args["learn"].(bool)
 	)
 
+	// The first interrupt stops the script, a second one kills term-present.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if ctl {
+		addr, _ := args["<addr>"].(string)
+		cmd, _ := args["<command>"].(string)
+		arg, _ := args["<arg>"].(string)
+		runCtl(addr, cmd, arg)
+		return
+	}
+
+	if watch {
+		run, _ := args["--run"].(bool)
+		fast, _ := args["--fast"].(bool)
+		w := &Watcher{Name: srcs[0], Run: run, Fast: fast, Snapshots: (&Snapshots{Dir: snaps, Check: check, Update: update}).For(srcs[0])}
+		w.Watch(ctx)
+		return
+	}
+
+	if lsp {
+		err := NewLSPServer().Serve(os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if learn {
+		file, _ := args["<file>"].(string)
+		runLearn(ctx, file)
+		return
+	}
+
+	if imp {
+		file, _ := args["<file>"].(string)
+		prompt, _ := args["--prompt"].(string)
+		kind := "tape"
+		if cast, _ := args["cast"].(bool); cast {
+			kind = "cast"
+		}
+		runImport(kind, file, prompt)
+		return
+	}
+
+	if export {
+		kind := "tape"
+		if sh, _ := args["--sh"].(bool); sh {
+			kind = "sh"
+		}
+		runExport(kind, srcs[0])
+		return
+	}
+
+	if format {
+		runFmt(srcs)
+		return
+	}
+
+	if lint {
+		runLint(srcs)
+		return
+	}
+
+	if test {
+		runTests(ctx, srcs, junit, &Snapshots{Dir: snaps, Check: true, Update: update})
+		return
+	}
+
+	opts := Options{
+		Snapshots: (&Snapshots{Dir: snaps, Check: check, Update: update}).For(srcs[0]),
+		Summary:   &Summary{},
+	}
+
+	src := srcs[0]
+
 	script, err := ParseFile(src)
+	if err == nil && (from != "" || to != "") {
+		script, err = script.Slice(from, to)
+	}
+
+	// Nothing is spawned or opened for a dry run.
+	if dryRun {
+		if err == nil {
+			err = WritePlan(os.Stdout, script)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err != nil {
-		Exec(os.Stderr, &OpOops{err.Error()})
+		Exec(ctx, os.Stderr, &OpOops{content: err.Error()}, opts)
 		os.Exit(1)
 	}
 
-	if upload {
-		rec := NewRecorder(os.Stdout)
-		rec.Meta.Populate()
+	var observers Observers
 
-		Exec(rec, script)
+	if events != "" {
+		sink, err := OpenEvents(events)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		// The sink is unbuffered, it is closed when term-present exits.
+		observers = append(observers, sink)
+	}
 
-		rec.Flush()
+	if notes != "" {
+		server := NewNotesServer(script)
+		l, err := net.Listen("tcp", notes)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		go http.Serve(l, server)
+		observers = append(observers, server)
+	}
+
+	if control != "" || step {
+		c := NewControl(script, step)
+		if control != "" {
+			l, err := listenControl(control)
+			if err != nil {
+				fmt.Printf("error: %s\n", err)
+				os.Exit(1)
+			}
+			go http.Serve(l, c)
+		}
+		opts.Control = c
+		observers = append(observers, c)
+	}
+
+	if len(observers) > 0 {
+		opts.Observer = observers
+	}
 
-		err := rec.Upload()
+	var (
+		out      io.Writer = os.Stdout
+		rec      *Recorder
+		resizers []func(rows, cols int)
+	)
+
+	if upload || record != "" {
+		rec = NewRecorder(os.Stdout)
+		rec.Meta.Populate()
+		out = rec
+		resizers = append(resizers, rec.Resize)
+	}
+
+	if broadcast != "" {
+		// The size defaults to 24x80 when stdin is not a terminal.
+		rows, cols, _ := pty.Getsize(os.Stdin)
+		b := NewBroadcaster(rows, cols)
+		l, err := net.Listen("tcp", broadcast)
 		if err != nil {
 			fmt.Printf("error: %s\n", err)
 			os.Exit(1)
 		}
-	} else {
-		Exec(os.Stdout, script)
+		go http.Serve(l, b)
+		out = io.MultiWriter(out, b)
+		resizers = append(resizers, b.Resize)
 	}
+
+	opts.OnResize = func(rows, cols int) {
+		for _, resize := range resizers {
+			resize(rows, cols)
+		}
+	}
+
+	err = Exec(ctx, out, script, opts)
+
+	if rec != nil {
+		rec.Flush()
+
+		if record != "" {
+			err := saveCast(rec, record)
+			if err != nil {
+				fmt.Printf("error: %s\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if upload && ctx.Err() == nil {
+			err := rec.Upload()
+			if err != nil {
+				fmt.Printf("error: %s\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		exit(130, summary, opts.Summary)
+	case err != nil, opts.Snapshots.Failed():
+		exit(1, summary, opts.Summary)
+	default:
+		exit(0, summary, opts.Summary)
+	}
+}
+
+// exit optionally reports the summary on stderr and exits with code.
+func exit(code int, report bool, summary *Summary) {
+	if report {
+		fmt.Fprintln(os.Stderr)
+		summary.Report(os.Stderr)
+	}
+	os.Exit(code)
+}
+
+func saveCast(rec *Recorder, name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	err = rec.WriteCast(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Scripts can also be written as Markdown, which reads well on GitHub:
+//
+//   - a heading starts a SECTION,
+//   - a paragraph or a list item is a SAY,
+//   - the lines of ```sh blocks, and lines starting with "$ " in any other
+//     fenced block, are RUNs,
+//   - the lines of ```type blocks are typed into the last RUN, each ending
+//     in a newline,
+//   - ```termp blocks hold plain script lines,
+//   - HTML comments hold directives, like <!-- TYPE :q\n -->. TYPE, EXPECT,
+//     NARRATION, OUTPUT-* and PAUSE, a BREATH while a RUN is running, belong
+//     to the last RUN. Comments which are not directives are ignored.
+
+func isMarkdown(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown":
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseMarkdown parses a script written as Markdown.
+func ParseMarkdown(source string) (Script, error) {
+	p := &parser{}
+	return p.parseLines(markdownLines(source), "", false, ".")
+}
+
+var (
+	mdHeading   = regexp.MustCompile(`^#{1,6}\s+(.*?)(\s+#+)?$`)
+	mdUnderline = regexp.MustCompile(`^(=+|-+)$`)
+	mdBreak     = regexp.MustCompile(`^([-*_]\s*){3,}$`)
+	mdListItem  = regexp.MustCompile(`^([-*+]|\d+[.)])\s+`)
+	mdFence     = regexp.MustCompile("^(```+|~~~+)\\s*([^\\s`]*)")
+)
+
+var shellLanguages = map[string]bool{
+	"sh": true, "bash": true, "shell": true, "zsh": true,
+}
+
+// markdownLines translates a Markdown document into script lines. There is
+// one script line for every line of the document, so positions stay the same.
+func markdownLines(source string) []string {
+	var (
+		lines   = strings.Split(source, "\n")
+		out     = make([]string, len(lines))
+		fence   string // the fence of the open code block
+		lang    string
+		comment bool // in an HTML comment
+		para    = -1 // the first line of the open paragraph
+		text    string
+		plain   bool // the paragraph is not a quote or a list item
+	)
+
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case fence != "":
+			if strings.HasPrefix(line, fence) && strings.Trim(line, fence[:1]) == "" {
+				fence = ""
+				continue
+			}
+			out[i] = codeLine(lang, line)
+			continue
+
+		case comment || strings.HasPrefix(line, "<!--"):
+			line = strings.TrimPrefix(line, "<!--")
+			comment = true
+			if j := strings.Index(line, "-->"); j >= 0 {
+				line = line[:j]
+				comment = false
+			}
+			out[i] = commentLine(line)
+			para = -1
+			continue
+
+		case line == "":
+			para = -1
+			continue
+		}
+
+		if m := mdFence.FindStringSubmatch(line); m != nil {
+			fence, lang = m[1], strings.ToLower(m[2])
+			para = -1
+			continue
+		}
+
+		if m := mdHeading.FindStringSubmatch(line); m != nil {
+			out[i] = "SECTION " + m[1]
+			para = -1
+			continue
+		}
+
+		if para == i-1 && para >= 0 && plain && mdUnderline.MatchString(line) {
+			out[para] = "SECTION " + text
+			para = -1
+			continue
+		}
+
+		if mdBreak.MatchString(line) {
+			para = -1
+			continue
+		}
+
+		quoted := strings.HasPrefix(line, ">")
+		line = strings.TrimSpace(strings.TrimPrefix(line, ">"))
+		item := mdListItem.FindString(line)
+		if item != "" {
+			line = line[len(item):]
+			para = -1
+		}
+
+		if para < 0 {
+			para, text = i, line
+			plain = !quoted && item == ""
+		} else {
+			text += " " + line
+		}
+		if text != "" {
+			out[para] = "SAY " + text
+		}
+	}
+
+	return out
+}
+
+// codeLine translates a line of a fenced code block.
+func codeLine(lang, line string) string {
+	switch {
+	case strings.HasPrefix(line, "$ "):
+		return "RUN " + strings.TrimSpace(line[2:])
+	case lang == "termp":
+		return line
+	case lang == "type":
+		return "- TYPE " + line + `\n`
+	case shellLanguages[lang] && !ignoreLine(line):
+		return "RUN " + line
+	default:
+		return ""
+	}
+}
+
+// commentLine translates a line of an HTML comment.
+func commentLine(line string) string {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "- ") {
+		return line
+	}
+
+	word, _, _ := strings.Cut(line, " ")
+	switch word {
+	case "PAUSE":
+		return "- BREATH"
+	case "TYPE", "EXPECT", "NARRATION", "OUTPUT-CONTAINS", "OUTPUT-MATCHES":
+		return "- " + line
+	case "SAY", "RUN", "SETUP", "BREATH", "RATE", "NOTE", "SECTION", "SNAPSHOT", "INCLUDE":
+		return line
+	default:
+		return ""
+	}
+}
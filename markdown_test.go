@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarkdownLines(t *testing.T) {
+	source := strings.Join([]string{
+		"Intro",
+		"=====",
+		"",
+		"Some prose",
+		"over two lines.",
+		"",
+		"- a list",
+		"* of things",
+		"",
+		"```bash",
+		"# a comment",
+		"$ ls",
+		"cd /tmp",
+		"```",
+		"```",
+		"$ echo hi",
+		"hi",
+		"```",
+		"<!-- TYPE :q\\n -->",
+		"<!-- PAUSE -->",
+		"<!--",
+		"EXPECT 1",
+		"just a comment",
+		"-->",
+		"## Outro ##",
+		"> quoted",
+		"---",
+		"~~~type",
+		"  x",
+		"~~~",
+	}, "\n")
+
+	want := []string{
+		"SECTION Intro", "",
+		"",
+		"SAY Some prose over two lines.", "",
+		"",
+		"SAY a list",
+		"SAY of things",
+		"",
+		"", "", "RUN ls", "RUN cd /tmp", "",
+		"", "RUN echo hi", "", "",
+		"- TYPE :q\\n",
+		"- BREATH",
+		"", "- EXPECT 1", "", "",
+		"SECTION Outro",
+		"SAY quoted",
+		"",
+		"", "- TYPE x\\n", "",
+	}
+
+	got := markdownLines(source)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseMarkdownFile(t *testing.T) {
+	script, err := ParseFile("markdown-example.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, op := range script {
+		got = append(got, describe(op))
+		if x, ok := op.(*OpExec); ok {
+			for _, sub := range x.Ops {
+				got = append(got, "- "+describe(sub))
+			}
+		}
+	}
+
+	want := []string{
+		"SECTION Basics",
+		"NOTE Markdown scripts read well on GitHub and run like any other script.",
+		"SAY This is a demonstration of `term-present`, written as Markdown.",
+		"SAY It can run the commands in shell blocks:",
+		"RUN ls -l",
+		"RUN cd ..",
+		"BREATH",
+		"SAY Or only the lines which start with a prompt:",
+		"RUN echo hello",
+		"SECTION Editors",
+		"SAY It can even take control of VIM.",
+		"RUN vim README.md",
+		`- TYPE "iHello world!\x1b\n"`,
+		`- TYPE ":wq\n"`,
+		"- BREATH",
+		"RUN cat README.md",
+		"RUN rm README.md",
+		"RUN doest-not-exist",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if p := opPos(script[11]); p.Line != 29 || p.Section != "Editors" {
+		t.Errorf("unexpected position %+v", p)
+	}
+}
+
+func TestParseMarkdownErrors(t *testing.T) {
+	_, err := ParseMarkdown("Some prose.\n<!-- TYPE x -->\n")
+	if err == nil || err.Error() != "line 2: unable to interpret the script." {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
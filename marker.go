@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The instrumented shell reports back through OSC sequences which carry a
+// random per session nonce, so program output can not fake them:
+//
+//	ESC ] 7777 ; <nonce> ; S ; <time> [; <cmd>] BEL           a command starts
+//	ESC ] 7777 ; <nonce> ; P ; <status> ; <time> ; <cwd> BEL  the prompt
+//
+// <time> is $EPOCHREALTIME, which is empty before bash 5. Only the shell of
+// learn reports the command line <cmd>.
+const markerPrefix = "\x1B]7777;"
+
+// maxMarker bounds how much output is held back while looking for the end
+// of a marker.
+const maxMarker = 4096
+
+type marker struct {
+	kind   byte
+	status uint8
+	time   time.Time
+	cwd    string
+	cmd    string
+}
+
+func newNonce() (string, error) {
+	var b [8]byte
+
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b[:]), nil
+}
+
+// markerVars returns the prompt variables which make bash print the markers.
+// The prompt marker is printed by PROMPT_COMMAND rather than PS1 as readline
+// prints PS1 again whenever it redraws the line.
+func markerVars(nonce string) []string {
+	return []string{
+		"PS0=" + markerPrefix + nonce + ";S;${EPOCHREALTIME}\x07",
+		"PS1=",
+		"PROMPT_COMMAND=printf '\\033]7777;" + nonce + ";P;%s;%s;%s\\007' \"$?\" \"$EPOCHREALTIME\" \"$PWD\"",
+	}
+}
+
+// learnVars makes the shell report the command line of every command, taken
+// from its history. The history is not saved. Unlike the audience of a
+// script, the presenter needs a prompt to type at; the prompt marker still
+// comes from PROMPT_COMMAND.
+func learnVars(nonce string) []string {
+	return []string{
+		"PS0=" + markerPrefix + nonce + ";S;${EPOCHREALTIME};$(fc -ln -0)\x07",
+		"PS1=\\$ ",
+		"HISTFILE=",
+		"HISTCONTROL=",
+		"HISTIGNORE=",
+	}
+}
+
+// parseMarker parses the marker at the start of p (which starts with ESC).
+// It returns the length of the marker, 0 when p does not start with a valid
+// marker or -1 when p ends before the marker is complete.
+func parseMarker(p []byte, nonce string) (int, marker) {
+	var m marker
+
+	if len(p) < len(markerPrefix) {
+		if !bytes.HasPrefix([]byte(markerPrefix), p) {
+			return 0, m
+		}
+		return -1, m
+	}
+	if !bytes.HasPrefix(p, []byte(markerPrefix)) {
+		return 0, m
+	}
+
+	end := bytes.IndexByte(p, 0x07)
+	if end < 0 {
+		if len(p) > maxMarker {
+			return 0, m
+		}
+		return -1, m
+	}
+
+	fields := strings.SplitN(string(p[len(markerPrefix):end]), ";", 5)
+	if len(fields) < 3 || fields[0] != nonce || len(fields[1]) != 1 {
+		return 0, m
+	}
+
+	m.kind = fields[1][0]
+
+	switch {
+
+	case m.kind == 'S':
+		m.time = parseEpoch(fields[2])
+		if len(fields) > 3 {
+			m.cmd = strings.TrimSpace(strings.Join(fields[3:], ";"))
+		}
+
+	case m.kind == 'P' && len(fields) == 5:
+		status, err := strconv.ParseUint(fields[2], 10, 8)
+		if err != nil {
+			return 0, m
+		}
+		m.status = uint8(status)
+		m.time = parseEpoch(fields[3])
+		m.cwd = fields[4]
+
+	default:
+		return 0, m
+
+	}
+
+	return end + 1, m
+}
+
+func parseEpoch(s string) time.Time {
+	sec, frac, _ := strings.Cut(strings.Replace(s, ",", ".", 1), ".")
+
+	n, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	var nsec int64
+	if frac != "" {
+		frac = (frac + "000000000")[:9]
+		nsec, err = strconv.ParseInt(frac, 10, 64)
+		if err != nil {
+			return time.Time{}
+		}
+	}
+
+	return time.Unix(n, nsec)
+}
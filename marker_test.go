@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMarker(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		n     int
+		want  marker
+	}{
+		{"start", start, len(start), marker{kind: 'S', time: time.Unix(100, 0)}},
+		{"prompt", prompt(3), len(prompt(3)), marker{kind: 'P', status: 3, time: time.Unix(100, 250000000), cwd: "/home/test"}},
+		{"trailing output", prompt(0) + "x", len(prompt(0)), marker{kind: 'P', time: time.Unix(100, 250000000), cwd: "/home/test"}},
+		{"cwd with separators", "\x1B]7777;test;P;0;;/a;b\x07", 22, marker{kind: 'P', cwd: "/a;b"}},
+		{"no time", "\x1B]7777;test;S;\x07", 15, marker{kind: 'S'}},
+		{"command", "\x1B]7777;test;S;;\t echo a;b\x07", 26, marker{kind: 'S', cmd: "echo a;b"}},
+		{"incomplete prefix", "\x1B]77", -1, marker{}},
+		{"incomplete", "\x1B]7777;test;P;0", -1, marker{}},
+		{"other escape", "\x1B[0m", 0, marker{}},
+		{"other osc", "\x1B]0;title\x07", 0, marker{}},
+		{"wrong nonce", "\x1B]7777;nope;P;0;;/\x07", 0, marker{}},
+		{"unknown kind", "\x1B]7777;test;X;0;;/\x07", 0, marker{}},
+		{"bad status", "\x1B]7777;test;P;256;;/\x07", 0, marker{}},
+		{"missing fields", "\x1B]7777;test;P;0\x07", 0, marker{}},
+		{"too long", markerPrefix + strings.Repeat("x", maxMarker), 0, marker{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n, m := parseMarker([]byte(test.input), "test")
+			if n != test.n {
+				t.Errorf("got length %d, want %d", n, test.n)
+			}
+			if n > 0 && (m.kind != test.want.kind || m.status != test.want.status ||
+				!m.time.Equal(test.want.time) || m.cwd != test.want.cwd || m.cmd != test.want.cmd) {
+				t.Errorf("got %+v, want %+v", m, test.want)
+			}
+		})
+	}
+}
+
+func TestParseEpoch(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"1700000000.123456", time.Unix(1700000000, 123456000)},
+		{"1700000000,5", time.Unix(1700000000, 500000000)},
+		{"12", time.Unix(12, 0)},
+		{"", time.Time{}},
+		{"x.1", time.Time{}},
+	}
+
+	for _, test := range tests {
+		if got := parseEpoch(test.in); !got.Equal(test.want) {
+			t.Errorf("parseEpoch(%q) = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestNewNonce(t *testing.T) {
+	a, err := newNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newNonce()
+
+	if len(a) != 16 || a == b {
+		t.Errorf("unexpected nonces %q and %q", a, b)
+	}
+}
+
+func TestLearnVarsPrompt(t *testing.T) {
+	// As BashShell sets them, the last value of a variable wins.
+	var ps1 string
+	for _, v := range append(markerVars("n"), learnVars("n")...) {
+		if value, ok := strings.CutPrefix(v, "PS1="); ok {
+			ps1 = value
+		}
+	}
+	if ps1 == "" {
+		t.Errorf("learn shows no prompt")
+	}
+}
+
+func TestBashRC(t *testing.T) {
+	got := bashRC([]string{"PS1=", "PROMPT_COMMAND=printf '%s' \"$?\""})
+	want := "declare +x PS1=''\ndeclare +x PROMPT_COMMAND='printf '\\''%s'\\'' \"$?\"'\nexec 3<&-\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// typingMark changes how the text of a RUN or a TYPE is typed from a point
+// on. It is written inline, {300ms} pauses and {rate=8} changes the typing
+// rate for the rest of the text. {{ is a literal { when a mark follows, so
+// {{300ms} types {300ms}.
+type typingMark struct {
+	at    int // where in the text
+	pause time.Duration
+	rate  int
+}
+
+var markToken = regexp.MustCompile(`^\{(?:rate=([1-9][0-9]{0,3})|([0-9]+(?:\.[0-9]+)?(?:ms|s)))\}`)
+
+// parseMark parses the mark at the start of s. It returns the length of the
+// mark, 0 when s does not start with one.
+func parseMark(s string) (int, typingMark) {
+	m := markToken.FindStringSubmatch(s)
+	if m == nil {
+		return 0, typingMark{}
+	}
+
+	var mark typingMark
+	if m[1] != "" {
+		mark.rate, _ = strconv.Atoi(m[1])
+	} else {
+		mark.pause, _ = time.ParseDuration(m[2])
+	}
+	return len(m[0]), mark
+}
+
+// splitMarks splits s at the marks in it. There is one more piece of text
+// than there are marks.
+func splitMarks(s string) ([]string, []typingMark) {
+	var (
+		pieces []string
+		marks  []typingMark
+		b      strings.Builder
+	)
+
+	for s != "" {
+		if strings.HasPrefix(s, "{{") {
+			if n, _ := parseMark(s[1:]); n > 0 {
+				b.WriteString(s[1 : n+1])
+				s = s[n+1:]
+				continue
+			}
+		}
+		if n, mark := parseMark(s); n > 0 {
+			pieces = append(pieces, b.String())
+			marks = append(marks, mark)
+			b.Reset()
+			s = s[n:]
+			continue
+		}
+		b.WriteByte(s[0])
+		s = s[1:]
+	}
+
+	return append(pieces, b.String()), marks
+}
+
+// parseMarked removes the marks from s.
+func parseMarked(s string) (string, []typingMark) {
+	pieces, marks := splitMarks(s)
+
+	var b strings.Builder
+	for i, piece := range pieces {
+		b.WriteString(piece)
+		if i < len(marks) {
+			marks[i].at = b.Len()
+		}
+	}
+	return b.String(), marks
+}
+
+// escapeBraces escapes the { which read as a mark.
+func escapeBraces(s string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			break
+		}
+		b.WriteString(s[:i+1])
+		if n, _ := parseMark(s[i:]); n > 0 {
+			b.WriteByte('{')
+		}
+		s = s[i+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+func formatMark(mark typingMark) string {
+	if mark.rate != 0 {
+		return "{rate=" + strconv.Itoa(mark.rate) + "}"
+	}
+	if mark.pause < time.Second && mark.pause%time.Millisecond == 0 {
+		return "{" + strconv.Itoa(int(mark.pause/time.Millisecond)) + "ms}"
+	}
+	return "{" + strconv.FormatFloat(mark.pause.Seconds(), 'f', -1, 64) + "s}"
+}
+
+// formatMarked is the reverse of parseMarked.
+func formatMarked(s string, marks []typingMark) string {
+	var (
+		b strings.Builder
+		i int
+	)
+	for _, mark := range marks {
+		b.WriteString(escapeBraces(s[i:mark.at]))
+		b.WriteString(formatMark(mark))
+		i = mark.at
+	}
+	b.WriteString(escapeBraces(s[i:]))
+	return b.String()
+}
+
+// typingTime returns how long typing s takes at rate, with the marks in it.
+func typingTime(s string, marks []typingMark, rate int) time.Duration {
+	var (
+		d time.Duration
+		i int
+	)
+	for _, mark := range marks {
+		d += time.Duration(utf8.RuneCountInString(s[i:mark.at])) * time.Second / time.Duration(rate)
+		d += mark.pause
+		if mark.rate != 0 {
+			rate = mark.rate
+		}
+		i = mark.at
+	}
+	return d + time.Duration(utf8.RuneCountInString(s[i:]))*time.Second/time.Duration(rate)
+}
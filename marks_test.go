@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMarked(t *testing.T) {
+	tests := []struct {
+		in, want string
+		marks    []typingMark
+	}{
+		{"ls", "ls", nil},
+		{"git com{300ms}mit{rate=8}", "git commit", []typingMark{{at: 7, pause: 300 * time.Millisecond}, {at: 10, rate: 8}}},
+		{"{1.5s}ls", "ls", []typingMark{{pause: 1500 * time.Millisecond}}},
+		{"echo {{1s} {1..3} ${HOME} {{.Id}}", "echo {1s} {1..3} ${HOME} {{.Id}}", nil},
+		{"{rate=0} {2m}", "{rate=0} {2m}", nil},
+	}
+
+	for _, test := range tests {
+		got, marks := parseMarked(test.in)
+		if got != test.want || !reflect.DeepEqual(marks, test.marks) {
+			t.Errorf("parseMarked(%q) = %q, %v, want %q, %v", test.in, got, marks, test.want, test.marks)
+		}
+		if back := formatMarked(got, marks); back != test.in {
+			t.Errorf("formatMarked(%q, %v) = %q, want %q", got, marks, back, test.in)
+		}
+	}
+}
+
+func TestParseTypedMarks(t *testing.T) {
+	x := parseTyped(`:wq{1s}<Enter>\e{rate=4}x`)
+
+	if want := ":wq\r\x1Bx"; x.content != want {
+		t.Errorf("got %q, want %q", x.content, want)
+	}
+	want := []typingMark{{at: 3, pause: time.Second}, {at: 5, rate: 4}}
+	if !reflect.DeepEqual(x.marks, want) {
+		t.Errorf("got marks %v, want %v", x.marks, want)
+	}
+	if len(x.keys) != 1 || x.keys[0].at != 3 {
+		t.Errorf("got keys %v, want <Enter> at 3", x.keys)
+	}
+	if got, want := typingTime(x.content, x.marks, 16), 5*time.Second/16+time.Second+time.Second/4; got != want {
+		t.Errorf("typingTime = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// NotesServer serves a page for the presenter with the current op, the ops
+// coming up, the notes for them and the progress through the script. The
+// page is updated over server-sent events as the script advances.
+type NotesServer struct {
+	script Script
+
+	mu      sync.Mutex
+	index   int
+	started time.Time
+	now     time.Time
+	done    bool
+	err     string
+	clients map[chan []byte]struct{}
+}
+
+type notesState struct {
+	Section  string   `json:"section"`
+	Sections int      `json:"sections"`
+	Part     int      `json:"part"`
+	Step     int      `json:"step"`
+	Steps    int      `json:"steps"`
+	Current  string   `json:"current"`
+	Notes    []string `json:"notes"`
+	Upcoming []string `json:"upcoming"`
+	Elapsed  float64  `json:"elapsed"` // seconds
+	Done     bool     `json:"done"`
+	Err      string   `json:"error,omitempty"`
+}
+
+// maxUpcoming is the number of ops shown after the current one.
+const maxUpcoming = 5
+
+func NewNotesServer(script Script) *NotesServer {
+	return &NotesServer{
+		script:  script,
+		index:   -1,
+		clients: map[chan []byte]struct{}{},
+	}
+}
+
+func (n *NotesServer) Observe(e *ExecEvent) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch {
+	case e.Kind == EventStart && e.Step > 0:
+		n.index = e.Step - 1
+		if n.started.IsZero() {
+			n.started = e.Time
+		}
+	case e.Kind == EventEnd:
+		n.done = true
+		n.err = e.Err
+	default:
+		return
+	}
+	n.now = e.Time
+
+	data := n.encode()
+	for c := range n.clients {
+		// Slow clients only get the latest state.
+		select {
+		case <-c:
+		default:
+		}
+		c <- data
+	}
+}
+
+func (n *NotesServer) state() *notesState {
+	var (
+		st = &notesState{Done: n.done, Err: n.err}
+		i  = max(n.index, 0)
+	)
+
+	if !n.started.IsZero() {
+		st.Elapsed = n.now.Sub(n.started).Seconds()
+	}
+
+	// Notes and sections belong to the op which follows them.
+	for i < len(n.script) && silent(n.script[i]) {
+		i++
+	}
+
+	// The steps are counted like Control.Status does.
+	for j, op := range n.script {
+		if x, ok := op.(*OpSection); ok {
+			st.Sections++
+			if j <= i {
+				st.Section = x.title
+				st.Part = st.Sections
+			}
+		}
+		if silent(op) {
+			continue
+		}
+		st.Steps++
+		if j <= i {
+			st.Step = st.Steps
+		}
+	}
+
+	if n.done {
+		return st
+	}
+
+	if i < len(n.script) {
+		st.Current = describe(n.script[i])
+	}
+
+	for j := i - 1; j >= 0 && silent(n.script[j]); j-- {
+		if x, ok := n.script[j].(*OpNote); ok {
+			st.Notes = append([]string{x.content}, st.Notes...)
+		}
+	}
+
+	for j := i + 1; j < len(n.script) && len(st.Upcoming) < maxUpcoming; j++ {
+		if !silent(n.script[j]) {
+			st.Upcoming = append(st.Upcoming, describe(n.script[j]))
+		}
+	}
+
+	return st
+}
+
+func (n *NotesServer) encode() []byte {
+	data, _ := json.Marshal(n.state())
+	return data
+}
+
+func (n *NotesServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(notesPage))
+	case "/state":
+		n.mu.Lock()
+		data := n.encode()
+		n.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	case "/events":
+		n.serveEvents(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (n *NotesServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	c := make(chan []byte, 1)
+
+	n.mu.Lock()
+	c <- n.encode()
+	n.clients[c] = struct{}{}
+	n.mu.Unlock()
+
+	defer func() {
+		n.mu.Lock()
+		delete(n.clients, c)
+		n.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-c:
+			_, err := fmt.Fprintf(w, "data: %s\n\n", data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+const notesPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Presenter notes</title>
+<style>
+body { background: #111; color: #ddd; font: 18px sans-serif; margin: 2em; }
+header { display: flex; justify-content: space-between; color: #888; }
+#current { font: 32px monospace; color: #6c6; margin: 1em 0; white-space: pre-wrap; }
+#notes li { font-size: 26px; color: #fff; margin: .5em 0; }
+#upcoming li { font-family: monospace; color: #888; white-space: pre-wrap; }
+#error { color: #e55; }
+</style>
+</head>
+<body>
+<header>
+  <span id="section"></span>
+  <span id="progress"></span>
+  <span id="elapsed">0:00</span>
+</header>
+<div id="current"></div>
+<ul id="notes"></ul>
+<h3>Up next</h3>
+<ul id="upcoming"></ul>
+<div id="error"></div>
+<script>
+var state = {elapsed: 0}, received = Date.now();
+
+function list(id, items) {
+  var ul = document.getElementById(id);
+  ul.innerHTML = "";
+  (items || []).forEach(function (item) {
+    var li = document.createElement("li");
+    li.textContent = item;
+    ul.appendChild(li);
+  });
+}
+
+function tick() {
+  var s = Math.floor(state.elapsed + (state.done || !state.step ? 0 : (Date.now() - received) / 1000));
+  document.getElementById("elapsed").textContent =
+    Math.floor(s / 60) + ":" + ("0" + s % 60).slice(-2);
+}
+
+new EventSource("events").onmessage = function (e) {
+  state = JSON.parse(e.data);
+  received = Date.now();
+  document.getElementById("section").textContent =
+    state.section ? state.section + " (" + state.part + "/" + state.sections + ")" : "";
+  document.getElementById("progress").textContent = state.step + "/" + state.steps;
+  document.getElementById("current").textContent = state.done ? "done" : state.current;
+  document.getElementById("error").textContent = state.error || "";
+  list("notes", state.notes);
+  list("upcoming", state.upcoming);
+  tick();
+};
+
+setInterval(tick, 1000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const notesScript = `SECTION Intro
+NOTE introduce yourself
+SAY hello
+RUN echo one
+SECTION Demo
+NOTE slowly
+NOTE then fast
+RUN echo two
+RUN echo three
+`
+
+func TestNotesStepsLikeControl(t *testing.T) {
+	script, err := Parse("SETUP cd /tmp\nSECTION A\nRUN echo one\nSECTION B\nRATE 30\nNOTE n\nRUN echo two\nRUN echo three")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err = script.Slice("B", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		n = NewNotesServer(script)
+		c = NewControl(script, false)
+	)
+	for step := 1; step <= len(script); step++ {
+		e := &ExecEvent{Kind: EventStart, Step: step}
+		n.Observe(e)
+		c.Observe(e)
+
+		got, want := n.state(), c.Status()
+		if got.Step != want.Step || got.Steps != want.Steps || got.Section != want.Section {
+			t.Errorf("step %d: notes at %d of %d in %q, control at %d of %d in %q",
+				step, got.Step, got.Steps, got.Section, want.Step, want.Steps, want.Section)
+		}
+	}
+}
+
+func TestNotesState(t *testing.T) {
+	script, err := Parse(notesScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNotesServer(script)
+	start := time.Unix(1000, 0)
+
+	got := n.state()
+	want := &notesState{
+		Section: "Intro", Sections: 2, Part: 1, Step: 1, Steps: 4,
+		Current:  "SAY hello",
+		Notes:    []string{"introduce yourself"},
+		Upcoming: []string{"RUN echo one", "RUN echo two", "RUN echo three"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	n.Observe(&ExecEvent{Kind: EventStart, Step: 1, Time: start})
+	n.Observe(&ExecEvent{Kind: EventStart, Step: 5, Time: start.Add(3 * time.Second)})
+
+	got = n.state()
+	want = &notesState{
+		Section: "Demo", Sections: 2, Part: 2, Step: 3, Steps: 4,
+		Current:  "RUN echo two",
+		Notes:    []string{"slowly", "then fast"},
+		Upcoming: []string{"RUN echo three"},
+		Elapsed:  3,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	n.Observe(&ExecEvent{Kind: EventEnd, Err: "oops", Time: start.Add(5 * time.Second)})
+
+	got = n.state()
+	if !got.Done || got.Err != "oops" || got.Current != "" || got.Elapsed != 5 {
+		t.Errorf("unexpected final state %+v", got)
+	}
+}
+
+func TestNotesServer(t *testing.T) {
+	script, err := Parse(notesScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNotesServer(script)
+	server := httptest.NewServer(n)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), `EventSource("events")`) {
+		t.Errorf("unexpected page %q", page)
+	}
+
+	resp, err = http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	events := bufio.NewReader(resp.Body)
+	next := func() *notesState {
+		t.Helper()
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				var st notesState
+				err := json.Unmarshal([]byte(data), &st)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return &st
+			}
+		}
+	}
+
+	if st := next(); st.Current != "SAY hello" {
+		t.Errorf("unexpected initial state %+v", st)
+	}
+
+	Exec(context.Background(), &bytes.Buffer{}, script, Options{
+		Headless: true,
+		Shell:    &fakeShell{pty: newFakePty(echoRun)},
+		Clock:    newFakeClock(),
+		Observer: n,
+	})
+
+	// Intermediate states may be dropped, the last one never is.
+	for {
+		st := next()
+		if st.Done {
+			if st.Err != "" || st.Step != 4 {
+				t.Errorf("unexpected final state %+v", st)
+			}
+			break
+		}
+	}
+
+	resp, err = http.Get(server.URL + "/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st notesState
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	if err != nil || !st.Done {
+		t.Errorf("unexpected state %+v (%v)", st, err)
+	}
+}
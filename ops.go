@@ -2,10 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"unicode/utf8"
@@ -14,91 +19,448 @@ import (
 	"github.com/creack/pty"
 )
 
-func Exec(w io.Writer, op Op) {
-	cmd := exec.Command("bash", "--noprofile", "--norc")
-	cmd.Env = append(os.Environ(), []string{
-		"PS1=\x1B@$?.",
-		"PS2=",
-		"PS3=",
-		"PS4=",
-		"PROMPT_COMMAND=",
-	}...)
-
-	rows, cols, err := pty.Getsize(os.Stdin)
-	if err != nil {
-		op := OpOops{err.Error()}
-		op.Exec(w, nil, nil)
-		return
+type Options struct {
+	Snapshots *Snapshots
+
+	// Headless runs without a controlling terminal and without any
+	// typing or pacing delays.
+	Headless bool
+
+	// OnRun is called with the result of every RUN.
+	OnRun func(r *RunResult)
+
+	// OnResize is called when the audience terminal changed its size.
+	OnResize func(rows, cols int)
+
+	// Observer is told about the progress of the script.
+	Observer Observer
+
+	// Control pauses, paces and moves the script around when set.
+	Control *Control
+
+	// Summary is filled in with the outcome of the script when set.
+	Summary *Summary
+
+	// Shell and Clock default to bash on a real pty and the wall clock.
+	Shell Shell
+	Clock Clock
+}
+
+// Exec runs op in a new shell. When ctx is cancelled the shell is killed and
+// Exec returns the context's error.
+func Exec(ctx context.Context, w io.Writer, op Op, opts Options) (err error) {
+	s := &Session{
+		ctx:       ctx,
+		w:         w,
+		snapshots: opts.Snapshots,
+		clock:     opts.Clock,
+		onRun:     opts.OnRun,
+		observer:  opts.Observer,
+		control:   opts.Control,
 	}
 
-	f, err := pty.StartWithSize(cmd, &pty.Winsize{
-		Rows: uint16(rows),
-		Cols: uint16(cols),
-	})
+	if !opts.Headless {
+		s.stdin = os.Stdin
+	}
+
+	if s.clock == nil {
+		s.clock = wallClock{}
+		if opts.Headless {
+			s.clock = fastClock{}
+		}
+	}
+
+	start := s.clock.Now()
+	defer func() {
+		d := s.clock.Now().Sub(start)
+		s.emit(&ExecEvent{Kind: EventEnd, Duration: d.Seconds(), Err: errString(err)})
+		if opts.Summary != nil {
+			opts.Summary.fill(s, op, err, d)
+		}
+	}()
+
+	shell := opts.Shell
+	if shell == nil {
+		shell = &BashShell{Stdin: s.stdin}
+	}
+
+	rows, cols, err := 24, 80, error(nil)
+	if s.stdin != nil {
+		rows, cols, err = pty.Getsize(s.stdin)
+		if err != nil {
+			return s.oops(err)
+		}
+	}
+
+	// Snapshots only compare equal on screens of the same size.
+	fixed := opts.Snapshots.Active()
+	if fixed {
+		rows, cols = snapshotRows, snapshotCols
+	}
+
+	s.screen = NewScreen(rows, cols)
+	s.w = io.MultiWriter(w, s.screen)
+
+	nonce, err := newNonce()
 	if err != nil {
-		op := OpOops{err.Error()}
-		op.Exec(w, nil, nil)
-		return
+		return s.oops(err)
 	}
-	defer f.Write([]byte{4})
 
-	ptyState, err := newPtyState(f)
+	p, err := shell.Start(rows, cols, nonce)
 	if err != nil {
-		op := OpOops{err.Error()}
-		op.Exec(w, nil, nil)
-		return
+		return s.oops(err)
 	}
-	defer ptyState.Restore()
+	s.pty = p
 
-	go io.Copy(f, os.Stdin)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Kill()
+		case <-done:
+		}
+	}()
+
+	defer func() {
+		close(done)
+		p.Close()
+
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			s.interrupted()
+		}
+
+		s.w.Write([]byte("\x1B[0m"))
+	}()
+
+	if s.stdin != nil {
+		defer pump.attach(s.stdin, p)()
+		if !fixed {
+			go s.followSize(done, opts.OnResize)
+		}
+	}
 
-	var cp = BashCopy{ptyState: ptyState, r: f, o: os.Stdin}
-	err = cp.WriteTo(w)
+	s.bash = &BashCopy{pty: p, nonce: nonce}
+	err = s.bash.Copy(s.w)
 	if err != nil {
-		op := OpOops{err.Error()}
-		op.Exec(w, f, ptyState)
+		if ctx.Err() != nil {
+			return err
+		}
+		return s.oops(err)
 	}
 
-	err = op.Exec(w, f, ptyState)
+	err = op.Exec(s)
 	if err != nil {
-		op := OpOops{err.Error()}
-		op.Exec(w, f, ptyState)
+		if ctx.Err() != nil {
+			return err
+		}
+		return s.oops(err)
+	}
+
+	return nil
+}
+
+// stdinPump copies what is typed at the audience terminal to the pty of the
+// running session. A read from stdin can not be stopped, so a copy per
+// session would outlive it and steal keys from the next one. Instead, one
+// pump is shared by all sessions.
+type stdinPump struct {
+	mu   sync.Mutex
+	once sync.Once
+	to   io.Writer
+}
+
+var pump stdinPump
+
+// attach sends what is read from in to w until the returned func is called.
+// What is read while no session is attached is dropped.
+func (p *stdinPump) attach(in io.Reader, w io.Writer) func() {
+	p.once.Do(func() {
+		go p.run(in)
+	})
+
+	p.mu.Lock()
+	p.to = w
+	p.mu.Unlock()
+
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.to == w {
+			p.to = nil
+		}
+	}
+}
+
+func (p *stdinPump) run(in io.Reader) {
+	buf := make([]byte, 1024)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			p.mu.Lock()
+			if p.to != nil {
+				p.to.Write(buf[:n])
+			}
+			p.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
 	}
 }
 
+// Session holds the state shared by all ops of a running script.
+type Session struct {
+	ctx       context.Context
+	w         io.Writer
+	stdin     *os.File
+	pty       Pty
+	bash      *BashCopy
+	screen    *Screen
+	snapshots *Snapshots
+	clock     Clock
+	onRun     func(r *RunResult)
+	observer  Observer
+	control   *Control
+
+	// replaying is set while commands are replayed, nothing is paced or
+	// reported then.
+	replaying bool
+
+	// rate is the typing rate set by RATE, 0 for the default.
+	rate int
+
+	// Progress, for the summary after an interrupt or failure.
+	executed int
+	current  Op
+	last     *RunResult
+}
+
+// hide keeps the output from the audience, and stops pacing, until the
+// returned func is called. The screen still follows the modes the output
+// sets.
+func (s *Session) hide() func() {
+	w := s.w
+	s.w, s.replaying = s.screen.Hidden(), true
+	return func() {
+		s.w, s.replaying = w, false
+	}
+}
+
+// sleep waits for d or until the session is cancelled.
+func (s *Session) sleep(d time.Duration) {
+	if s.replaying {
+		return
+	}
+
+	if s.control != nil {
+		d = s.control.scale(d)
+	}
+
+	select {
+	case <-s.clock.After(d):
+	case <-s.ctx.Done():
+	}
+}
+
+// followSize resizes the pty and the screen along with the audience
+// terminal until done is closed.
+func (s *Session) followSize(done <-chan struct{}, onResize func(rows, cols int)) {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-winch:
+		}
+
+		rows, cols, err := pty.Getsize(s.stdin)
+		if err != nil {
+			continue
+		}
+
+		s.pty.Resize(rows, cols)
+		s.screen.Resize(rows, cols)
+		if onResize != nil {
+			onResize(rows, cols)
+		}
+	}
+}
+
+// interrupted tells the audience where the script was stopped.
+func (s *Session) interrupted() {
+	msg := fmt.Sprintf("interrupted after %d ops", s.executed)
+	if s.current != nil {
+		msg += " at " + describe(s.current)
+	}
+
+	fmt.Fprintf(s.w, "\x1B[0m\r\n\x1B[31m! %s.\x1B[0m\r\n", msg)
+}
+
+// oops reports err to the audience and returns it.
+func (s *Session) oops(err error) error {
+	op := OpOops{content: err.Error()}
+	op.Exec(s)
+	return err
+}
+
 type Op interface {
-	Exec(w io.Writer, pty *os.File, ptyState *PtyState) error
+	Exec(s *Session) error
 }
 
+// Pos is where an op was defined.
+type Pos struct {
+	File    string // the included file the op is from, empty for the script itself
+	Line    int    // 1-based, 0 when the op is not from a script
+	Section string // the title of the section the op is in
+}
+
+func (p *Pos) pos() *Pos { return p }
+
+// opPos returns the position of op, or nil when it has none.
+func opPos(op Op) *Pos {
+	if x, ok := op.(interface{ pos() *Pos }); ok {
+		return x.pos()
+	}
+	return nil
+}
+
+// The pauses which give the audience time to follow along.
+const (
+	opPause     = 250 * time.Millisecond // before each visible op
+	enterPause  = 100 * time.Millisecond // between typing a command and running it
+	subOpsPause = 500 * time.Millisecond // before the sub-ops of a RUN
+	breathPause = time.Second
+	typingRate  = 16 // runes per second
+)
+
 type Script []Op
 
-func (s Script) Exec(w io.Writer, pty *os.File, ptyState *PtyState) error {
-	for _, op := range s {
-		time.Sleep(250 * time.Millisecond)
-		err := op.Exec(w, pty, ptyState)
+func (s Script) Exec(sess *Session) error {
+	return s.exec(sess, true)
+}
+
+// exec runs the ops in order. Only the top level script tracks the progress
+// of the session.
+func (s Script) exec(sess *Session, track bool) error {
+	for i := 0; i < len(s); i++ {
+		if track && sess.control != nil {
+			var err error
+			i, err = sess.control.wait(sess.ctx, i)
+			if err != nil {
+				return err
+			}
+			if i >= len(s) {
+				break
+			}
+		}
+
+		op := s[i]
+
+		if !silent(op) {
+			sess.sleep(opPause)
+		}
+
+		err := sess.ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		step := 0
+		if track {
+			sess.current = op
+			step = i + 1
+		}
+
+		start := sess.clock.Now()
+		sess.emit(&ExecEvent{Kind: EventStart, Step: step, Op: describe(op)})
+		err = op.Exec(sess)
+		sess.emit(&ExecEvent{
+			Kind:     EventFinish,
+			Step:     step,
+			Op:       describe(op),
+			Duration: sess.clock.Now().Sub(start).Seconds(),
+			Err:      errString(err),
+		})
 		if err != nil {
 			return err
 		}
+
+		if track {
+			sess.executed++
+		}
 	}
 	return nil
 }
 
+// describe returns a short description of op for messages.
+func describe(op Op) string {
+	switch x := op.(type) {
+	case *OpEcho:
+		return "SAY " + x.content
+	case *OpExec:
+		if x.setup {
+			return "SETUP " + formatMarked(x.cmd, x.marks)
+		}
+		return "RUN " + formatMarked(x.cmd, x.marks)
+	case *OpType:
+		return "TYPE " + strconv.Quote(x.content)
+	case *OpBreath:
+		if x.d != 0 {
+			return "BREATH " + x.d.String()
+		}
+		return "BREATH"
+	case *OpSnapshot:
+		return "SNAPSHOT " + x.name
+	case *OpNote:
+		return "NOTE " + x.content
+	case *OpSection:
+		return "SECTION " + x.title
+	case *OpRate:
+		return "RATE " + strconv.Itoa(x.rate)
+	case *OpReplay:
+		return fmt.Sprintf("REPLAY %d ops", len(x.Ops))
+	case *OpComment:
+		return x.text
+	case *OpInclude:
+		return "INCLUDE " + x.path
+	default:
+		return fmt.Sprintf("%T", op)
+	}
+}
+
+// silent reports whether op is invisible to the audience.
+func silent(op Op) bool {
+	switch x := op.(type) {
+	case *OpNote, *OpSection, *OpReplay, *OpComment, *OpRate:
+		return true
+	case *OpExec:
+		return x.setup
+	default:
+		return false
+	}
+}
+
 type OpEcho struct {
+	Pos
 	content string
 }
 
-func (e *OpEcho) Exec(w io.Writer, pty *os.File, ptyState *PtyState) error {
-	_, err := w.Write([]byte("\x1B[35m"))
+func (e *OpEcho) Exec(s *Session) error {
+	_, err := s.w.Write([]byte("\x1B[35m"))
 	if err != nil {
 		return err
 	}
 
-	err = shellTyper(w, "# "+e.content, 0, true)
+	err = shellTyper(s, s.w, "# "+e.content, nil, 0, true)
 	if err != nil {
 		return err
 	}
 
-	_, err = w.Write([]byte("\x1B[0m\r\n"))
+	_, err = s.w.Write([]byte("\x1B[0m\r\n"))
 	if err != nil {
 		return err
 	}
@@ -107,11 +469,38 @@ func (e *OpEcho) Exec(w io.Writer, pty *os.File, ptyState *PtyState) error {
 }
 
 type OpType struct {
+	Pos
 	content string
+	keys    []typedKey // typed by name, in the order of content
+	marks   []typingMark
 }
 
-func (e *OpType) Exec(w io.Writer, pty *os.File, ptyState *PtyState) error {
-	err := shellTyper(pty, e.content, 0, false)
+// typed returns what e types, the cursor keys typed by name depend on
+// whether the application asked for application cursor keys. Their
+// sequences are as long either way, so the marks stay in place.
+func (e *OpType) typed(app bool) string {
+	if !app {
+		return e.content
+	}
+
+	var (
+		b strings.Builder
+		i int
+	)
+	for _, k := range e.keys {
+		if k.app == "" {
+			continue
+		}
+		b.WriteString(e.content[i:k.at])
+		b.WriteString(strings.Repeat(k.app, k.n))
+		i = k.at + len(k.seq)*k.n
+	}
+	b.WriteString(e.content[i:])
+	return b.String()
+}
+
+func (e *OpType) Exec(s *Session) error {
+	err := shellTyper(s, s.pty, e.typed(s.screen.AppCursor()), e.marks, 0, false)
 	if err != nil {
 		return err
 	}
@@ -120,21 +509,22 @@ func (e *OpType) Exec(w io.Writer, pty *os.File, ptyState *PtyState) error {
 }
 
 type OpOops struct {
+	Pos
 	content string
 }
 
-func (e *OpOops) Exec(w io.Writer, pty *os.File, ptyState *PtyState) error {
-	_, err := w.Write([]byte("\x1B[31m"))
+func (e *OpOops) Exec(s *Session) error {
+	_, err := s.w.Write([]byte("\x1B[31m"))
 	if err != nil {
 		return err
 	}
 
-	err = shellTyper(w, "! "+e.content, 0, true)
+	err = shellTyper(s, s.w, "! "+e.content, nil, 0, true)
 	if err != nil {
 		return err
 	}
 
-	_, err = w.Write([]byte("\x1B[0m\r\n"))
+	_, err = s.w.Write([]byte("\x1B[0m\r\n"))
 	if err != nil {
 		return err
 	}
@@ -143,36 +533,102 @@ func (e *OpOops) Exec(w io.Writer, pty *os.File, ptyState *PtyState) error {
 }
 
 type OpExec struct {
-	cmd string
-	Ops Script
+	Pos
+	cmd    string
+	marks  []typingMark // in cmd
+	expect uint8
+	checks []OutputCheck
+	Ops    Script
+
+	// narration marks commands which do not change the state of the shell,
+	// they are skipped when replaying a script.
+	narration bool
+
+	// setup marks commands which prepare the shell, they run without being
+	// shown to the audience.
+	setup bool
+}
+
+type RunResult struct {
+	Cmd      string
+	Status   uint8
+	Output   string
+	Cwd      string
+	Duration time.Duration // including typing
+	Elapsed  time.Duration // as measured by the shell
+	Err      error
 }
 
-func (e *OpExec) Exec(w io.Writer, pty *os.File, ptyState *PtyState) error {
-	_, err := w.Write([]byte("\x1B[0m\x1B[32m$ \x1B[0m"))
+func (e *OpExec) Exec(s *Session) error {
+	if e.setup && !s.replaying {
+		defer s.hide()()
+	}
+
+	var (
+		start  = s.clock.Now()
+		output bytes.Buffer
+	)
+
+	status, err := e.exec(s, &output)
+	out := stripANSI(output.String())
+
+	for _, c := range e.checks {
+		if err != nil {
+			break
+		}
+		err = c.Check(out)
+	}
+
+	s.last = &RunResult{
+		Cmd:      e.cmd,
+		Status:   status,
+		Output:   out,
+		Cwd:      s.bash.cwd,
+		Duration: s.clock.Now().Sub(start),
+		Elapsed:  s.bash.elapsed,
+		Err:      err,
+	}
+
+	s.emit(&ExecEvent{
+		Kind:     EventExit,
+		Op:       describe(e),
+		Status:   &status,
+		Duration: s.last.Duration.Seconds(),
+	})
+
+	if s.onRun != nil {
+		s.onRun(s.last)
+	}
+
+	return err
+}
+
+func (e *OpExec) exec(s *Session, output io.Writer) (uint8, error) {
+	_, err := s.w.Write([]byte("\x1B[0m\x1B[32m$ \x1B[0m"))
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	var cErr = make(chan error)
+	var cErr = make(chan error, 1)
 	go func() {
-		err = shellTyper(pty, e.cmd, 0, false)
+		err := shellTyper(s, s.pty, e.cmd, e.marks, 0, false)
 		if err != nil {
 			cErr <- err
 			return
 		}
 
-		time.Sleep(100 * time.Millisecond)
+		s.sleep(enterPause)
 
-		_, err = pty.Write([]byte("\n"))
+		_, err = s.pty.Write([]byte("\n"))
 		if err != nil {
 			cErr <- err
 			return
 		}
 
 		if len(e.Ops) > 0 {
-			time.Sleep(500 * time.Millisecond)
+			s.sleep(subOpsPause)
 
-			err = e.Ops.Exec(w, pty, ptyState)
+			err = e.Ops.exec(s, false)
 			if err != nil {
 				cErr <- err
 				return
@@ -182,41 +638,147 @@ func (e *OpExec) Exec(w io.Writer, pty *os.File, ptyState *PtyState) error {
 		cErr <- nil
 	}()
 
-	var cp = BashCopy{ptyState: ptyState, r: pty, o: os.Stdin}
-	err = cp.WriteTo(w)
+	s.bash.output = output
+	err = s.bash.Copy(io.MultiWriter(s.w, eventWriter{s}))
+	s.bash.output = nil
+	typeErr := <-cErr
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if typeErr != nil {
+		return s.bash.code, typeErr
 	}
 
-	err = <-cErr
-	if err != nil {
-		return err
+	if s.bash.code != e.expect {
+		if e.expect != 0 {
+			return s.bash.code, fmt.Errorf("the command exited with status %d (expected %d).", s.bash.code, e.expect)
+		}
+		return s.bash.code, fmt.Errorf("the command exited with status %d.", s.bash.code)
 	}
 
-	if cp.code != 0 {
-		return fmt.Errorf("the command exited with status %d.", cp.code)
+	return s.bash.code, nil
+}
+
+var ansiSequence = regexp.MustCompile(`\x1B(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1B]*(\x07|\x1B\\)|[ -/]*[0-~])`)
+
+func stripANSI(s string) string {
+	s = ansiSequence.ReplaceAllString(s, "")
+	return strings.ReplaceAll(s, "\r", "")
+}
+
+// OutputCheck asserts something about the output of a finished RUN.
+type OutputCheck interface {
+	Check(output string) error
+}
+
+type OutputContains struct {
+	text string
+}
+
+func (c *OutputContains) Check(output string) error {
+	if !strings.Contains(output, c.text) {
+		return fmt.Errorf("the output does not contain %q.", c.text)
 	}
+	return nil
+}
 
+type OutputMatches struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func (c *OutputMatches) Check(output string) error {
+	if !c.re.MatchString(output) {
+		return fmt.Errorf("the output does not match /%s/.", c.pattern)
+	}
 	return nil
 }
 
-type OpBreath struct{ nl bool }
+type OpBreath struct {
+	Pos
+	nl bool
+	d  time.Duration // 0 for the default
+}
 
-func (e *OpBreath) Exec(w io.Writer, pty *os.File, ptyState *PtyState) error {
+func (e *OpBreath) Exec(s *Session) error {
 	if e.nl {
-		_, err := w.Write([]byte("\r\n"))
+		_, err := s.w.Write([]byte("\r\n"))
 		if err != nil {
 			return err
 		}
 	}
 
-	time.Sleep(time.Second)
+	d := e.d
+	if d == 0 {
+		d = breathPause
+	}
+	s.sleep(d)
 	return nil
 }
 
-func shellTyper(w io.Writer, s string, rate int, out bool) error {
+type OpSnapshot struct {
+	Pos
+	name string
+}
+
+func (e *OpSnapshot) Exec(s *Session) error {
+	if s.replaying || s.snapshots == nil {
+		return nil
+	}
+	return s.snapshots.Save(e.name, s.screen.Text())
+}
+
+// OpNote is a note for the presenter. It is never shown to the audience.
+type OpNote struct {
+	Pos
+	content string
+}
+
+func (e *OpNote) Exec(s *Session) error { return nil }
+
+// OpSection starts a new section of the script.
+type OpSection struct {
+	Pos
+	title string
+}
+
+func (e *OpSection) Exec(s *Session) error { return nil }
+
+// OpRate changes how fast the rest of the script is typed.
+type OpRate struct {
+	Pos
+	rate int // runes per second
+}
+
+func (e *OpRate) Exec(s *Session) error {
+	s.rate = e.rate
+	return nil
+}
+
+// OpComment is a comment or a blank line, only kept when formatting.
+type OpComment struct {
+	Pos
+	text string
+}
+
+func (e *OpComment) Exec(s *Session) error { return nil }
+
+// OpInclude is an INCLUDE which was not resolved, only kept when formatting.
+type OpInclude struct {
+	Pos
+	path string
+}
+
+func (e *OpInclude) Exec(s *Session) error {
+	return fmt.Errorf("%s was not included.", e.path)
+}
+
+func shellTyper(sess *Session, w io.Writer, s string, marks []typingMark, rate int, out bool) error {
+	if rate == 0 {
+		rate = sess.rate
+	}
 	if rate == 0 {
-		rate = 16
+		rate = typingRate
 	}
 
 	var (
@@ -224,9 +786,28 @@ func shellTyper(w io.Writer, s string, rate int, out bool) error {
 		delay = time.Second / time.Duration(rate)
 	)
 
+	// mark applies the marks up to where the typing is.
+	mark := func() {
+		for len(marks) > 0 && marks[0].at <= len(s)-len(p) {
+			sess.sleep(marks[0].pause)
+			if marks[0].rate != 0 {
+				delay = time.Second / time.Duration(marks[0].rate)
+			}
+			marks = marks[1:]
+		}
+	}
+
 	for len(p) > 0 {
-		time.Sleep(delay)
+		mark()
+		sess.sleep(delay)
+
+		err := sess.ctx.Err()
+		if err != nil {
+			return err
+		}
+
 		r, n := utf8.DecodeRune(p)
+		sess.emit(&ExecEvent{Kind: EventType, Data: string(p[:n])})
 		if r == '\n' && out {
 			_, err := w.Write([]byte("\r\n"))
 			if err != nil {
@@ -240,6 +821,7 @@ func shellTyper(w io.Writer, s string, rate int, out bool) error {
 		}
 		p = p[n:]
 	}
+	mark()
 
 	return nil
 }
@@ -247,6 +829,7 @@ func shellTyper(w io.Writer, s string, rate int, out bool) error {
 type PtyState struct {
 	pty      *os.File
 	oldState syscall.Termios
+	mirrored *syscall.Termios
 }
 
 func newPtyState(pty *os.File) (*PtyState, error) {
@@ -260,15 +843,14 @@ func newPtyState(pty *os.File) (*PtyState, error) {
 		return nil, err
 	}
 
-	return &PtyState{pty, oldState}, nil
+	return &PtyState{pty: pty, oldState: oldState}, nil
 }
 
+// CopyTo copies the terminal modes of the pty to f. The modes are only
+// written when they changed since the last call.
 func (p *PtyState) CopyTo(f *os.File) error {
 	var state = new(syscall.Termios)
 
-	p.pty.Sync()
-	f.Sync()
-
 	if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL,
 		p.pty.Fd(),
 		ioctlReadTermios,
@@ -277,6 +859,10 @@ func (p *PtyState) CopyTo(f *os.File) error {
 		return err
 	}
 
+	if p.mirrored != nil && *p.mirrored == *state {
+		return nil
+	}
+
 	if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL,
 		f.Fd(),
 		ioctlWriteTermios,
@@ -285,9 +871,7 @@ func (p *PtyState) CopyTo(f *os.File) error {
 		return err
 	}
 
-	p.pty.Sync()
-	f.Sync()
-
+	p.mirrored = state
 	return nil
 }
 
@@ -305,77 +889,127 @@ func (p *PtyState) Restore() error {
 	return p.pty.Sync()
 }
 
-var ptybuf bytes.Buffer
-
+// BashCopy copies the output of the shell until it prints its prompt. The
+// markers printed by the shell (see marker.go) are not copied. Output read
+// past the prompt is kept for the next call to Copy.
 type BashCopy struct {
-	ptyState *PtyState
-	r        *os.File
-	o        *os.File
-	code     uint8
+	pty     Pty
+	nonce   string
+	buf     []byte
+	pending []byte // unprocessed output, always a window of buf
+
+	// onMarker is called with every marker as it is read.
+	onMarker func(m marker)
+
+	// output, when set, also gets the output of the running command, from
+	// its start marker on. Whatever bash and the terminal echo before is
+	// left out.
+	output  io.Writer
+	running bool
+
+	// Reported by the last prompt.
+	code    uint8
+	cwd     string
+	elapsed time.Duration
+	started time.Time
 }
 
-func (b *BashCopy) WriteTo(w io.Writer) error {
-	var (
-		buf   [1]byte
-		code  uint8
-		state int
-	)
+func (b *BashCopy) Copy(w io.Writer) error {
+	if b.buf == nil {
+		b.buf = make([]byte, 32*1024)
+	}
 
 	for {
-		err := b.ptyState.CopyTo(b.o)
-		if err != nil {
-			panic("oops")
+		done, err := b.scan(w)
+		if err != nil || done {
+			return err
 		}
 
-		switch state {
+		// Only an incomplete marker is left; move it to the front.
+		b.pending = b.buf[:copy(b.buf, b.pending)]
 
-		case 0:
-			_, err := b.r.Read(buf[:])
+		n, err := b.pty.Read(b.buf[len(b.pending):])
+		if n > 0 {
+			b.pending = b.buf[:len(b.pending)+n]
+
+			err := b.pty.Mirror()
 			if err != nil {
 				return err
 			}
-			ptybuf.WriteByte(buf[0])
+		}
+		if err != nil {
+			b.flush(w, len(b.pending))
+			return err
+		}
+	}
+}
 
-			if buf[0] == 0x1B {
-				state = 1
-			} else {
-				w.Write(buf[:])
-			}
+// scan writes the pending output up to the next marker to w. It reports
+// whether a complete marker was consumed.
+func (b *BashCopy) scan(w io.Writer) (bool, error) {
+	for len(b.pending) > 0 {
+		i := bytes.IndexByte(b.pending, 0x1B)
+		if i < 0 {
+			return false, b.flush(w, len(b.pending))
+		}
 
-		case 1:
-			_, err := b.r.Read(buf[:])
-			if err != nil {
-				return err
-			}
-			ptybuf.WriteByte(buf[0])
-
-			if buf[0] == '@' {
-				state = 2
-			} else {
-				w.Write([]byte{0x1B})
-				w.Write(buf[:])
-				state = 0
-			}
+		err := b.flush(w, i)
+		if err != nil {
+			return false, err
+		}
+
+		n, m := parseMarker(b.pending, b.nonce)
 
-		case 2:
-			_, err := b.r.Read(buf[:])
+		switch {
+		case n < 0:
+			// The marker is incomplete; wait for more output.
+			return false, nil
+
+		case n == 0:
+			err := b.flush(w, 1)
 			if err != nil {
-				return err
-			}
-			ptybuf.WriteByte(buf[0])
-
-			if '0' <= buf[0] && buf[0] <= '9' {
-				code = code*10 + (buf[0] - '0')
-			} else if buf[0] == '.' {
-				b.code = code
-				state = 3
-			} else {
-				panic("error while reading exit status")
+				return false, err
 			}
+			continue
+		}
 
-		case 3:
-			return nil
+		if b.onMarker != nil {
+			b.onMarker(m)
+		}
 
+		switch {
+		case m.kind == 'S':
+			b.started = m.time
+			b.running = true
+			b.pending = b.pending[n:]
+
+		default:
+			b.code = m.status
+			b.cwd = m.cwd
+			b.elapsed = 0
+			if !b.started.IsZero() && !m.time.IsZero() {
+				b.elapsed = m.time.Sub(b.started)
+			}
+			b.started = time.Time{}
+			b.running = false
+			b.pending = b.pending[n:]
+			b.pty.AwaitPrompt()
+			return true, nil
 		}
 	}
+
+	return false, nil
+}
+
+func (b *BashCopy) flush(w io.Writer, n int) error {
+	if n == 0 {
+		return nil
+	}
+
+	_, err := w.Write(b.pending[:n])
+	if err == nil && b.running && b.output != nil {
+		_, err = b.output.Write(b.pending[:n])
+	}
+	b.pending = b.pending[n:]
+	return err
 }
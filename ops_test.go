@@ -0,0 +1,718 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShellTyper(t *testing.T) {
+	var (
+		s, _, clock, _ = newTestSession(echoRun)
+		buf            bytes.Buffer
+	)
+
+	err := shellTyper(s, &buf, "ab\nç", nil, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := buf.String(), "ab\r\nç"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := clock.Total(), 4*time.Second/16; got != want {
+		t.Errorf("typing took %s, want %s", got, want)
+	}
+}
+
+func TestShellTyperRaw(t *testing.T) {
+	var (
+		s, _, clock, _ = newTestSession(echoRun)
+		buf            bytes.Buffer
+	)
+
+	err := shellTyper(s, &buf, ":x\n", nil, 4, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := buf.String(), ":x\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := clock.Total(), 3*time.Second/4; got != want {
+		t.Errorf("typing took %s, want %s", got, want)
+	}
+}
+
+func TestShellTyperMarks(t *testing.T) {
+	var (
+		s, _, clock, _ = newTestSession(echoRun)
+		buf            bytes.Buffer
+	)
+
+	cmd, marks := parseMarked("ab{300ms}cd{rate=4}ef{1s}")
+	err := shellTyper(s, &buf, cmd, marks, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := buf.String(), "abcdef"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	want := 4*time.Second/16 + 300*time.Millisecond + 2*time.Second/4 + time.Second
+	if got := clock.Total(); got != want {
+		t.Errorf("typing took %s, want %s", got, want)
+	}
+	if got := typingTime(cmd, marks, 16); got != want {
+		t.Errorf("typingTime = %s, want %s", got, want)
+	}
+}
+
+func TestOpEcho(t *testing.T) {
+	s, _, _, out := newTestSession(echoRun)
+
+	err := (&OpEcho{content: "hi"}).Exec(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := out.String(), "\x1B[35m# hi\x1B[0m\r\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestOpOops(t *testing.T) {
+	s, _, _, _ := newTestSession(echoRun)
+
+	err := s.oops(errors.New("boom"))
+	if err == nil || err.Error() != "boom" {
+		t.Errorf("expected the error to be returned, got %v", err)
+	}
+
+	if got, want := s.screen.Text(), "! boom\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestOpBreath(t *testing.T) {
+	s, _, clock, out := newTestSession(echoRun)
+
+	err := (&OpBreath{nl: true}).Exec(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "\r\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	if clock.Total() != time.Second {
+		t.Errorf("breath took %s", clock.Total())
+	}
+}
+
+func TestOpExec(t *testing.T) {
+	var (
+		s, p, _, _ = newTestSession(echoRun)
+		results    []*RunResult
+	)
+	s.onRun = func(r *RunResult) { results = append(results, r) }
+
+	err := (&OpExec{cmd: "echo hello"}).Exec(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := p.Typed(), "echo hello\n"; got != want {
+		t.Errorf("typed %q, want %q", got, want)
+	}
+	if got, want := s.screen.Text(), "$ echo hello\nhello\n"; got != want {
+		t.Errorf("screen %q, want %q", got, want)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	r := results[0]
+	if r.Cmd != "echo hello" || r.Status != 0 || r.Output != "hello\n" || r.Err != nil {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if r.Duration == 0 {
+		t.Errorf("expected the duration to be measured on the clock")
+	}
+}
+
+func TestOpExecSetup(t *testing.T) {
+	s, p, clock, out := newTestSession(echoRun)
+
+	err := (&OpExec{cmd: "echo hidden", setup: true}).Exec(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := p.Typed(), "echo hidden\n"; got != want {
+		t.Errorf("typed %q, want %q", got, want)
+	}
+	if out.Len() != 0 || clock.Total() != 0 {
+		t.Errorf("the setup was shown: %q after %s", out.String(), clock.Total())
+	}
+	if s.replaying {
+		t.Errorf("the session is still replaying")
+	}
+
+	err = (&OpExec{cmd: "exit 1", setup: true}).Exec(s)
+	if err == nil {
+		t.Errorf("expected a failing setup to fail")
+	}
+}
+
+func TestOpRate(t *testing.T) {
+	s, _, clock, _ := newTestSession(echoRun)
+
+	(&OpRate{rate: 4}).Exec(s)
+	err := (&OpBreath{d: time.Second / 2}).Exec(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = (&OpEcho{content: "hi"}).Exec(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := clock.Total(), time.Second/2+4*time.Second/4; got != want {
+		t.Errorf("took %s, want %s", got, want)
+	}
+}
+
+func TestOpExecStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     *OpExec
+		status uint8
+		err    string
+	}{
+		{"success", &OpExec{cmd: "exit 0"}, 0, ""},
+		{"failure", &OpExec{cmd: "exit 3"}, 3, "the command exited with status 3."},
+		{"expected failure", &OpExec{cmd: "nope", expect: 127}, 127, ""},
+		{"unexpected success", &OpExec{cmd: "exit 0", expect: 1}, 0, "the command exited with status 0 (expected 1)."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				s, _, _, _ = newTestSession(echoRun)
+				result     *RunResult
+			)
+			s.onRun = func(r *RunResult) { result = r }
+
+			err := test.op.Exec(s)
+			if test.err == "" && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+			if result.Status != test.status {
+				t.Errorf("got status %d, want %d", result.Status, test.status)
+			}
+		})
+	}
+}
+
+func TestOpExecOutputChecks(t *testing.T) {
+	script, err := Parse(strings.Join([]string{
+		"RUN echo google.com. 300 IN A 1.2.3.4",
+		"- OUTPUT-CONTAINS IN A",
+		"- OUTPUT-MATCHES ^google\\.com\\.\\s+\\d+\\s+IN\\s+A\\s",
+		"RUN echo nothing",
+		"- OUTPUT-CONTAINS something",
+	}, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, _, _, _ := newTestSession(echoRun)
+
+	err = script[0].Exec(s)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	err = script[1].Exec(s)
+	if err == nil || err.Error() != `the output does not contain "something".` {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestOpExecType(t *testing.T) {
+	s, p, _, _ := newTestSession(func(lines []string) (string, uint8, bool) {
+		if len(lines) < 2 {
+			return "", 0, false
+		}
+		return "got " + lines[1] + "\n", 0, true
+	})
+
+	op := &OpExec{cmd: "read x; echo got $x", Ops: Script{&OpType{content: "hi\n"}}}
+	err := op.Exec(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := p.Typed(), "read x; echo got $x\nhi\n"; got != want {
+		t.Errorf("typed %q, want %q", got, want)
+	}
+	if got, want := s.screen.Text(), "$ read x; echo got $x\nhi\ngot hi\n"; got != want {
+		t.Errorf("screen %q, want %q", got, want)
+	}
+}
+
+func TestOpSnapshot(t *testing.T) {
+	var (
+		dir        = t.TempDir()
+		s, _, _, _ = newTestSession(echoRun)
+	)
+	s.snapshots = &Snapshots{Dir: dir}
+
+	err := (&OpSnapshot{name: "x"}).Exec(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x.txt")); !os.IsNotExist(err) {
+		t.Errorf("snapshot written without --update-snapshots")
+	}
+	s.snapshots.Update = true
+
+	s.w.Write([]byte("hello\r\nworld   \r\n\r\n"))
+
+	err = (&OpSnapshot{name: "x"}).Exec(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "x.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello\nworld\n" {
+		t.Errorf("unexpected snapshot %q", data)
+	}
+
+	s.snapshots.Check, s.snapshots.Update = true, false
+	err = (&OpSnapshot{name: "x"}).Exec(s)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	s.w.Write([]byte("again"))
+	err = (&OpSnapshot{name: "x"}).Exec(s)
+	if err == nil || !strings.Contains(err.Error(), "+ again") {
+		t.Errorf("expected a diff, got %v", err)
+	}
+	if !s.snapshots.Failed() {
+		t.Errorf("expected the snapshots to have failed")
+	}
+
+	err = (&OpSnapshot{name: "missing"}).Exec(s)
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("expected a missing snapshot error, got %v", err)
+	}
+}
+
+func TestSnapshotsFor(t *testing.T) {
+	snaps := &Snapshots{Dir: "snapshots", Check: true}
+	if got, want := snaps.For("demo/a.termp").Dir, filepath.Join("demo", "snapshots"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !snaps.For("a.termp").Check {
+		t.Errorf("For lost Check")
+	}
+
+	snaps.Dir = "/tmp/snaps"
+	if got := snaps.For("demo/a.termp").Dir; got != "/tmp/snaps" {
+		t.Errorf("got %q, want /tmp/snaps", got)
+	}
+}
+
+func TestScriptExec(t *testing.T) {
+	s, _, clock, _ := newTestSession(echoRun)
+
+	script := Script{
+		&OpExec{cmd: "echo one"},
+		&OpExec{cmd: "exit 1"},
+		&OpExec{cmd: "echo three"},
+	}
+
+	err := script.Exec(s)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if got, want := s.screen.Text(), "$ echo one\none\n$ exit 1\n"; got != want {
+		t.Errorf("screen %q, want %q", got, want)
+	}
+
+	// 2 pauses between ops, 2 commands typed and entered.
+	want := 2*250*time.Millisecond +
+		time.Duration(len("echo one")+len("exit 1"))*time.Second/16 +
+		2*100*time.Millisecond
+	if clock.Total() != want {
+		t.Errorf("script took %s, want %s", clock.Total(), want)
+	}
+}
+
+func TestExec(t *testing.T) {
+	var (
+		shell   = &fakeShell{pty: newFakePty(echoRun)}
+		clock   = newFakeClock()
+		out     bytes.Buffer
+		results []*RunResult
+	)
+
+	script, err := Parse("SAY hi\nRUN echo hello\nRUN missing\nRUN echo skipped")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Exec(context.Background(), &out, script, Options{
+		Headless: true,
+		Shell:    shell,
+		Clock:    clock,
+		OnRun:    func(r *RunResult) { results = append(results, r) },
+	})
+	if err == nil || err.Error() != "the command exited with status 127." {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if shell.rows != 24 || shell.cols != 80 {
+		t.Errorf("unexpected size %dx%d", shell.cols, shell.rows)
+	}
+	if len(results) != 2 {
+		t.Errorf("expected 2 results, got %d", len(results))
+	}
+	if !shell.pty.closed {
+		t.Errorf("expected the shell to be closed")
+	}
+	if clock.Total() == 0 {
+		t.Errorf("expected the fake clock to be used")
+	}
+
+	screen := NewScreen(24, 80)
+	screen.Write(out.Bytes())
+	want := "# hi\n$ echo hello\nhello\n$ missing\nbash: missing: command not found\n! the command exited with status 127.\n"
+	if got := screen.Text(); got != want {
+		t.Errorf("screen %q, want %q", got, want)
+	}
+}
+
+func TestExecInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		shell = &fakeShell{pty: newFakePty(func(lines []string) (string, uint8, bool) {
+			if lines[0] == "sleep 100" {
+				cancel()
+				return "", 0, false
+			}
+			return echoRun(lines)
+		})}
+		out bytes.Buffer
+	)
+
+	script, err := Parse("RUN echo one\nRUN sleep 100\nRUN echo three")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Exec(ctx, &out, script, Options{Headless: true, Shell: shell})
+	if err != context.Canceled {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	screen := NewScreen(24, 80)
+	screen.Write(out.Bytes())
+	want := "$ echo one\none\n$ sleep 100\n\n! interrupted after 1 ops at RUN sleep 100.\n"
+	if got := screen.Text(); got != want {
+		t.Errorf("screen %q, want %q", got, want)
+	}
+	if !bytes.HasSuffix(out.Bytes(), []byte("\x1B[0m")) {
+		t.Errorf("expected the attributes to be reset")
+	}
+}
+
+func TestScriptExecCancelled(t *testing.T) {
+	s, p, _, _ := newTestSession(echoRun)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.ctx = ctx
+
+	err := Script{&OpExec{cmd: "echo one"}}.Exec(s)
+	if err != context.Canceled {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if p.Typed() != "" {
+		t.Errorf("expected nothing to be typed, got %q", p.Typed())
+	}
+}
+
+func TestBashCopy(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		output string
+		code   uint8
+	}{
+		{"prompt only", prompt(0), "", 0},
+		{"output", "hi\r\n" + prompt(0), "hi\r\n", 0},
+		{"status", prompt(127), "", 127},
+		{"escape sequences", "\x1B[1mhi\x1B[0m" + prompt(2), "\x1B[1mhi\x1B[0m", 2},
+		{"stops at marker", "a" + prompt(1) + "b", "a", 1},
+		{"start marker", "ls\r\n" + start + "a\r\n" + prompt(0), "ls\r\na\r\n", 0},
+		{"old marker", "\x1B@1." + prompt(0), "\x1B@1.", 0},
+		{"other osc", "\x1B]0;title\x07" + prompt(0), "\x1B]0;title\x07", 0},
+		{"spoofed marker", "\x1B]7777;guess;P;1;;/\x07" + prompt(0), "\x1B]7777;guess;P;1;;/\x07", 0},
+		{"malformed marker", "\x1B]7777;test;P;x\x07" + prompt(0), "\x1B]7777;test;P;x\x07", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				p   = newFakePty(echoRun)
+				out bytes.Buffer
+			)
+			p.out.WriteString(test.input)
+
+			cp := BashCopy{pty: p, nonce: "test"}
+			err := cp.Copy(&out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if out.String() != test.output {
+				t.Errorf("got %q, want %q", out.String(), test.output)
+			}
+			if cp.code != test.code {
+				t.Errorf("got code %d, want %d", cp.code, test.code)
+			}
+			if p.mirrors == 0 {
+				t.Errorf("expected the terminal modes to be mirrored")
+			}
+		})
+	}
+}
+
+// chunkPty returns its output in reads of at most size bytes.
+type chunkPty struct {
+	data []byte
+	size int
+}
+
+func (p *chunkPty) Read(b []byte) (int, error) {
+	if len(p.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b[:min(len(b), p.size)], p.data)
+	p.data = p.data[n:]
+	return n, nil
+}
+
+func (p *chunkPty) Write(b []byte) (int, error) { return len(b), nil }
+func (p *chunkPty) Mirror() error               { return nil }
+func (p *chunkPty) AwaitPrompt()                {}
+func (p *chunkPty) Close() error                { return nil }
+func (p *chunkPty) Kill() error                 { return nil }
+func (p *chunkPty) Resize(rows, cols int) error { return nil }
+
+func TestBashCopySplitReads(t *testing.T) {
+	input := "one\x1B[0m" + prompt(0) + "two" + start + "\x1B]7777;x" + prompt(12) + "\x1B"
+
+	for size := 1; size <= len(input); size++ {
+		var (
+			cp  = BashCopy{pty: &chunkPty{[]byte(input), size}, nonce: "test"}
+			out bytes.Buffer
+		)
+
+		err := cp.Copy(&out)
+		if err != nil || out.String() != "one\x1B[0m" || cp.code != 0 {
+			t.Fatalf("size %d: got %q, %d, %v", size, out.String(), cp.code, err)
+		}
+
+		out.Reset()
+		err = cp.Copy(&out)
+		if err != nil || out.String() != "two\x1B]7777;x" || cp.code != 12 {
+			t.Fatalf("size %d: got %q, %d, %v", size, out.String(), cp.code, err)
+		}
+		if cp.cwd != "/home/test" || cp.elapsed != 250*time.Millisecond {
+			t.Fatalf("size %d: got %q, %s", size, cp.cwd, cp.elapsed)
+		}
+
+		out.Reset()
+		err = cp.Copy(&out)
+		if err != io.EOF || out.String() != "\x1B" {
+			t.Fatalf("size %d: got %q, %v", size, out.String(), err)
+		}
+	}
+}
+
+func TestBashCopyUnterminatedOSC(t *testing.T) {
+	var (
+		osc   = markerPrefix + strings.Repeat("x", 2*maxMarker)
+		input = osc + prompt(3)
+		cp    = BashCopy{pty: &chunkPty{[]byte(input), 512}, nonce: "test"}
+		out   bytes.Buffer
+	)
+
+	err := cp.Copy(&out)
+	if err != nil || out.String() != osc || cp.code != 3 {
+		t.Errorf("got %q, %d, %v", out.String(), cp.code, err)
+	}
+}
+
+func BenchmarkBashCopy(b *testing.B) {
+	var (
+		line  = []byte("00000010  8c 0d 04 09 03 02 b3 7a  e6 48 05 8f 3e 3f ff d2  |.......z.H..>?..|\r\n")
+		input = append(bytes.Repeat(line, 1<<14), prompt(0)...)
+	)
+
+	for _, size := range []int{1, 512, 32 * 1024} {
+		b.Run(fmt.Sprintf("read=%d", size), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				cp := BashCopy{pty: &chunkPty{input, size}, nonce: "test"}
+				err := cp.Copy(io.Discard)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// syncBuffer is a bytes.Buffer which may be written while it is read.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestStdinPump(t *testing.T) {
+	var (
+		p      stdinPump
+		r, w   = io.Pipe()
+		first  = &syncBuffer{}
+		second = &syncBuffer{}
+	)
+	defer w.Close()
+
+	wait := func(b *syncBuffer, want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for b.String() != want && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if b.String() != want {
+			t.Fatalf("got %q, want %q", b.String(), want)
+		}
+	}
+
+	detach := p.attach(r, first)
+	w.Write([]byte("a"))
+	wait(first, "a")
+	detach()
+
+	// Dropped, no session is attached.
+	w.Write([]byte("x"))
+
+	defer p.attach(r, second)()
+	w.Write([]byte("b"))
+	wait(second, "b")
+
+	if first.String() != "a" {
+		t.Errorf("the first session got %q after it ended", first.String())
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"", ""},
+		{"a\r\nb\r\n", "a\nb\n"},
+		{"\x1B[01;34mdir\x1B[0m\r\n", "dir\n"},
+		{"\x1B]0;title\x07y\x1B(B\r\n", "y\n"},
+		{"\x1B[?2004hprompt", "prompt"},
+	}
+
+	for _, test := range tests {
+		if got := stripANSI(test.raw); got != test.want {
+			t.Errorf("stripANSI(%q) = %q, want %q", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestOpExecOutputAfterStart(t *testing.T) {
+	s, p, _, _ := newTestSession(func(lines []string) (string, uint8, bool) {
+		return "", 0, true
+	})
+	// The terminal echoed the command line before readline did.
+	p.out.WriteString("echo foo | grep bar; true\r\n")
+
+	op, err := Parse("RUN echo foo | grep bar; true\n- OUTPUT-CONTAINS bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = op[0].Exec(s)
+	if err == nil || !strings.Contains(err.Error(), `does not contain "bar"`) {
+		t.Errorf("expected the check to fail, got %v", err)
+	}
+	if s.last.Output != "" {
+		t.Errorf("got output %q, want none", s.last.Output)
+	}
+}
+
+func TestOpTypeAppCursor(t *testing.T) {
+	s, p, _, _ := newTestSession(echoRun)
+
+	op, err := parseSubLine("TYPE <Up>k")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.w.Write([]byte("\x1B[?1h"))
+	err = op.Exec(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.w.Write([]byte("\x1B[?1l"))
+	err = op.Exec(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := p.Typed(), "\x1BOAk\x1B[Ak"; got != want {
+		t.Errorf("typed %q, want %q", got, want)
+	}
+}
@@ -2,51 +2,208 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+// ParseError is an error at a line of a script.
+type ParseError struct {
+	File string // empty when parsing a string
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
 func ParseFile(name string) (Script, error) {
+	script, _, err := parseFiles(name)
+	return script, err
+}
+
+// parseFiles parses a script along with the files it includes. It returns
+// the names of all files it read, also when parsing failed.
+func parseFiles(name string) (Script, []string, error) {
+	p := &parser{}
+	script, err := p.parseFile(name, false)
+	return script, p.files, err
+}
+
+// Parse parses a script. INCLUDEs are relative to the working directory.
+func Parse(source string) (Script, error) {
+	p := &parser{}
+	return p.parse(source, "", false, ".")
+}
+
+// parseShallow parses a script without reading the files it includes. It
+// keeps comments, blank lines and INCLUDEs as ops, so the script can be
+// formatted again.
+func parseShallow(source string) (Script, error) {
+	p := &parser{shallow: true}
+	return p.parse(source, "", false, ".")
+}
+
+type parser struct {
+	files   []string
+	stack   []string
+	section string
+	shallow bool
+}
+
+func (p *parser) parseFile(name string, included bool) (Script, error) {
+	for _, f := range p.stack {
+		if f == filepath.Clean(name) {
+			return nil, fmt.Errorf("%s includes itself.", name)
+		}
+	}
+
+	p.files = append(p.files, name)
 	data, err := os.ReadFile(name)
 	if err != nil {
 		return nil, err
 	}
 
-	return Parse(string(data))
+	p.stack = append(p.stack, filepath.Clean(name))
+	defer func() {
+		p.stack = p.stack[:len(p.stack)-1]
+	}()
+
+	return p.parse(string(data), name, included, filepath.Dir(name))
 }
 
-func Parse(source string) (Script, error) {
+// parse parses the source of a script called name, as Markdown when name
+// says so. INCLUDEs are relative to dir and the ops of included files remember
+// where they came from.
+func (p *parser) parse(source, name string, included bool, dir string) (Script, error) {
+	if isMarkdown(name) {
+		return p.parseLines(markdownLines(source), name, included, dir)
+	}
+	return p.parseLines(strings.Split(source, "\n"), name, included, dir)
+}
+
+func (p *parser) parseLines(lines []string, name string, included bool, dir string) (Script, error) {
 	var (
 		script  Script
-		lines   = strings.Split(source, "\n")
 		lastRun *OpExec
+		file    string
+		pending Script // comments which belong to whatever comes next
 	)
 
-	for _, line := range lines {
+	flush := func(to *Script) {
+		*to = append(*to, pending...)
+		pending = nil
+	}
+
+	if included {
+		file = name
+	}
+
+	for i, line := range lines {
+		fail := func(err error) (Script, error) {
+			return nil, &ParseError{File: name, Line: i + 1, Err: err}
+		}
+
 		line = strings.TrimSpace(line)
 		if ignoreLine(line) {
+			if p.shallow {
+				pos := Pos{File: file, Line: i + 1, Section: p.section}
+				pending = append(pending, &OpComment{Pos: pos, text: line})
+			}
 			continue
 		}
 
 		if strings.HasPrefix(line, "- ") {
 			if lastRun == nil {
-				return nil, errors.New("unable to interpret the script.")
+				return fail(errors.New("unable to interpret the script."))
 			}
+			flush(&lastRun.Ops)
 
 			line = strings.TrimSpace(line[2:])
+
+			if strings.HasPrefix(line, "EXPECT ") {
+				code, err := strconv.ParseUint(strings.TrimSpace(line[7:]), 10, 8)
+				if err != nil {
+					return fail(errors.New("invalid exit status in EXPECT."))
+				}
+
+				lastRun.expect = uint8(code)
+				continue
+			}
+
+			if line == "NARRATION" {
+				lastRun.narration = true
+				continue
+			}
+
+			if strings.HasPrefix(line, "OUTPUT-") {
+				check, err := parseOutputCheck(line)
+				if err != nil {
+					return fail(err)
+				}
+
+				lastRun.checks = append(lastRun.checks, check)
+				continue
+			}
+
 			op, err := parseSubLine(line)
 			if err != nil {
-				return nil, err
+				return fail(err)
 			}
 
+			*opPos(op) = Pos{File: file, Line: i + 1, Section: p.section}
 			lastRun.Ops = append(lastRun.Ops, op)
 
+		} else if strings.HasPrefix(line, "INCLUDE ") && len(line) > 8 {
+			lastRun = nil
+			flush(&script)
+
+			path := strings.TrimSpace(line[8:])
+			if p.shallow {
+				pos := Pos{File: file, Line: i + 1, Section: p.section}
+				script = append(script, &OpInclude{Pos: pos, path: path})
+				continue
+			}
+
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+
+			ops, err := p.parseFile(path, true)
+			if err != nil {
+				var perr *ParseError
+				if errors.As(err, &perr) {
+					return nil, err
+				}
+				return fail(err)
+			}
+
+			script = append(script, ops...)
+
 		} else {
 			lastRun = nil
+			flush(&script)
+
 			op, err := parseLine(line)
 			if err != nil {
-				return nil, err
+				return fail(err)
+			}
+
+			if x, ok := op.(*OpSection); ok {
+				p.section = x.title
 			}
+			*opPos(op) = Pos{File: file, Line: i + 1, Section: p.section}
 
 			if x, ok := op.(*OpExec); ok {
 				lastRun = x
@@ -56,6 +213,7 @@ func Parse(source string) (Script, error) {
 		}
 	}
 
+	flush(&script)
 	return script, nil
 }
 
@@ -67,13 +225,34 @@ func parseLine(line string) (Op, error) {
 	switch {
 
 	case strings.HasPrefix(line, "SAY ") && len(line) > 4:
-		return &OpEcho{line[4:]}, nil
+		return &OpEcho{content: line[4:]}, nil
 
 	case strings.HasPrefix(line, "RUN ") && len(line) > 4:
-		return &OpExec{line[4:], nil}, nil
+		cmd, marks := parseMarked(line[4:])
+		return &OpExec{cmd: cmd, marks: marks}, nil
+
+	case strings.HasPrefix(line, "SETUP ") && len(line) > 6:
+		cmd, marks := parseMarked(line[6:])
+		return &OpExec{cmd: cmd, marks: marks, setup: true}, nil
+
+	case line == "BREATH" || strings.HasPrefix(line, "BREATH "):
+		return parseBreath(line[6:], true)
+
+	case strings.HasPrefix(line, "RATE "):
+		rate, err := strconv.Atoi(strings.TrimSpace(line[5:]))
+		if err != nil || rate <= 0 {
+			return nil, errors.New("invalid rate in RATE.")
+		}
+		return &OpRate{rate: rate}, nil
+
+	case strings.HasPrefix(line, "NOTE ") && len(line) > 5:
+		return &OpNote{content: line[5:]}, nil
 
-	case line == "BREATH":
-		return &OpBreath{nl: true}, nil
+	case strings.HasPrefix(line, "SECTION ") && len(line) > 8:
+		return &OpSection{title: line[8:]}, nil
+
+	case strings.HasPrefix(line, "SNAPSHOT "):
+		return parseSnapshot(line[9:])
 
 	default:
 		return nil, errors.New("unable to interpret the script.")
@@ -85,11 +264,13 @@ func parseSubLine(line string) (Op, error) {
 	switch {
 
 	case strings.HasPrefix(line, "TYPE ") && len(line) > 5:
-		line = replaceEscapeSequences(line[5:])
-		return &OpType{line}, nil
+		return parseTyped(line[5:]), nil
+
+	case line == "BREATH" || strings.HasPrefix(line, "BREATH "):
+		return parseBreath(line[6:], false)
 
-	case line == "BREATH":
-		return &OpBreath{}, nil
+	case strings.HasPrefix(line, "SNAPSHOT "):
+		return parseSnapshot(line[9:])
 
 	default:
 		return nil, errors.New("unable to interpret the script.")
@@ -97,45 +278,192 @@ func parseSubLine(line string) (Op, error) {
 	}
 }
 
+func parseBreath(arg string, nl bool) (Op, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return &OpBreath{nl: nl}, nil
+	}
+
+	d, err := time.ParseDuration(arg)
+	if err != nil || d <= 0 {
+		return nil, errors.New("invalid duration in BREATH.")
+	}
+	return &OpBreath{nl: nl, d: d}, nil
+}
+
+func parseOutputCheck(line string) (OutputCheck, error) {
+	switch {
+
+	case strings.HasPrefix(line, "OUTPUT-CONTAINS ") && len(line) > 16:
+		return &OutputContains{replaceEscapeSequences(line[16:])}, nil
+
+	case strings.HasPrefix(line, "OUTPUT-MATCHES ") && len(line) > 15:
+		pattern := line[15:]
+		re, err := regexp.Compile("(?m)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern in OUTPUT-MATCHES: %s.", err)
+		}
+		return &OutputMatches{pattern, re}, nil
+
+	default:
+		return nil, errors.New("unable to interpret the script.")
+
+	}
+}
+
+var snapshotName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func parseSnapshot(name string) (Op, error) {
+	name = strings.TrimSpace(name)
+	if !snapshotName.MatchString(name) || strings.HasPrefix(name, ".") {
+		return nil, errors.New("invalid snapshot name.")
+	}
+
+	return &OpSnapshot{name: name}, nil
+}
+
+// controlChars are the C0 control characters, TYPE and OUTPUT-CONTAINS
+// accept them as \NAME.
+var controlChars = [...]struct{ name, desc string }{
+	{"NUL", "Null char"},
+	{"SOH", "Start of Heading"},
+	{"STX", "Start of Text"},
+	{"ETX", "End of Text"},
+	{"EOT", "End of Transmission"},
+	{"ENQ", "Enquiry"},
+	{"ACK", "Acknowledgment"},
+	{"BEL", "Bell"},
+	{"BS", "Back Space"},
+	{"HT", "Horizontal Tab"},
+	{"LF", "Line Feed"},
+	{"VT", "Vertical Tab"},
+	{"FF", "Form Feed"},
+	{"CR", "Carriage Return"},
+	{"SO", "Shift Out / X-On"},
+	{"SI", "Shift In / X-Off"},
+	{"DLE", "Data Line Escape"},
+	{"DC1", "Device Control 1 (oft. XON)"},
+	{"DC2", "Device Control 2"},
+	{"DC3", "Device Control 3 (oft. XOFF)"},
+	{"DC4", "Device Control 4"},
+	{"NAK", "Negative Acknowledgement"},
+	{"SYN", "Synchronous Idle"},
+	{"ETB", "End of Transmit Block"},
+	{"CAN", "Cancel"},
+	{"EM", "End of Medium"},
+	{"SUB", "Substitute"},
+	{"ESC", "Escape"},
+	{"FS", "File Separator"},
+	{"GS", "Group Separator"},
+	{"RS", "Record Separator"},
+	{"US", "Unit Separator"},
+}
+
+// escapes are the escape sequences besides \xNN and \u{NNNN}. SOH comes
+// before SO, so the longer name wins.
+var escapes = func() [][2]string {
+	pairs := [][2]string{
+		{`\e`, "\x1B"},
+		{`\n`, "\n"},
+		{`\t`, "\t"},
+		{`\v`, "\v"},
+		{`\\`, "\\"},
+	}
+	for c, char := range controlChars {
+		pairs = append(pairs, [2]string{`\` + char.name, string(rune(c))})
+	}
+	return pairs
+}()
+
+var (
+	hexEscape     = regexp.MustCompile(`^\\x([0-9A-Fa-f]{2})`)
+	unicodeEscape = regexp.MustCompile(`^\\u\{([0-9A-Fa-f]{1,6})\}`)
+)
+
+// parseTyped parses the text of a TYPE. The marks are split off first, so
+// escapes never start one.
+func parseTyped(s string) *OpType {
+	var (
+		x             = &OpType{}
+		b             strings.Builder
+		pieces, marks = splitMarks(s)
+	)
+
+	for i, piece := range pieces {
+		content, keys := unescape(piece, true)
+		for _, k := range keys {
+			k.at += b.Len()
+			x.keys = append(x.keys, k)
+		}
+		b.WriteString(content)
+
+		if i < len(marks) {
+			marks[i].at = b.Len()
+			x.marks = append(x.marks, marks[i])
+		}
+	}
+
+	x.content = b.String()
+	return x
+}
+
 func replaceEscapeSequences(s string) string {
-	r := strings.NewReplacer(
-		"\\e", "\x1B",
-		"\\n", "\n",
-		"\\t", "\t",
-		"\\v", "\v",
-		"\\\\", "\\",
-		"\\NUL", "\x00", // Null char
-		"\\SOH", "\x01", // Start of Heading
-		"\\STX", "\x02", // Start of Text
-		"\\ETX", "\x03", // End of Text
-		"\\EOT", "\x04", // End of Transmission
-		"\\ENQ", "\x05", // Enquiry
-		"\\ACK", "\x06", // Acknowledgment
-		"\\BEL", "\x07", // Bell
-		"\\BS", "\x08", // Back Space
-		"\\HT", "\x09", // Horizontal Tab
-		"\\LF", "\x0A", // Line Feed
-		"\\VT", "\x0B", // Vertical Tab
-		"\\FF", "\x0C", // Form Feed
-		"\\CR", "\x0D", // Carriage Return
-		"\\SO", "\x0E", // Shift Out / X-On
-		"\\SI", "\x0F", // Shift In / X-Off
-		"\\DLE", "\x10", // Data Line Escape
-		"\\DC1", "\x11", // Device Control 1 (oft. XON)
-		"\\DC2", "\x12", // Device Control 2
-		"\\DC3", "\x13", // Device Control 3 (oft. XOFF)
-		"\\DC4", "\x14", // Device Control 4
-		"\\NAK", "\x15", // Negative Acknowledgement
-		"\\SYN", "\x16", // Synchronous Idle
-		"\\ETB", "\x17", // End of Transmit Block
-		"\\CAN", "\x18", // Cancel
-		"\\EM", "\x19", // End of Medium
-		"\\SUB", "\x1A", // Substitute
-		"\\ESC", "\x1B", // Escape
-		"\\FS", "\x1C", // File Separator
-		"\\GS", "\x1D", // Group Separator
-		"\\RS", "\x1E", // Record Separator
-		"\\US", "\x1F", // Unit Separator
+	content, _ := unescape(s, false)
+	return content
+}
+
+// unescape replaces the escape sequences in s. With keys, which TYPE allows,
+// keys are typed by name too, like <Up> or <Down*5>, and \< is a <.
+func unescape(s string, keys bool) (string, []typedKey) {
+	var (
+		b     strings.Builder
+		typed []typedKey
 	)
-	return r.Replace(s)
+
+next:
+	for s != "" {
+		switch {
+		case keys && strings.HasPrefix(s, `\<`):
+			b.WriteByte('<')
+			s = s[2:]
+			continue
+
+		case keys && s[0] == '<':
+			if n, k := parseKeyToken(s); n > 0 {
+				k.at = b.Len()
+				b.WriteString(strings.Repeat(k.seq, k.n))
+				typed = append(typed, k)
+				s = s[n:]
+				continue
+			}
+
+		case s[0] == '\\':
+			if m := hexEscape.FindStringSubmatch(s); m != nil {
+				c, _ := strconv.ParseUint(m[1], 16, 8)
+				b.WriteByte(byte(c))
+				s = s[len(m[0]):]
+				continue
+			}
+			if m := unicodeEscape.FindStringSubmatch(s); m != nil {
+				r, _ := strconv.ParseUint(m[1], 16, 32)
+				if utf8.ValidRune(rune(r)) {
+					b.WriteRune(rune(r))
+					s = s[len(m[0]):]
+					continue
+				}
+			}
+			for _, e := range escapes {
+				if strings.HasPrefix(s, e[0]) {
+					b.WriteString(e[1])
+					s = s[len(e[0]):]
+					continue next
+				}
+			}
+		}
+
+		b.WriteByte(s[0])
+		s = s[1:]
+	}
+
+	return b.String(), typed
 }
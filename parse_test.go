@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   Script
+	}{
+		{
+			name:   "empty",
+			source: "",
+			want:   nil,
+		},
+		{
+			name:   "comments and blank lines",
+			source: "# a comment\n\n   \n# another\n",
+			want:   nil,
+		},
+		{
+			name:   "say",
+			source: "SAY hello world",
+			want:   Script{&OpEcho{content: "hello world"}},
+		},
+		{
+			name:   "indented",
+			source: "   SAY hello   \n\tRUN ls\t",
+			want:   Script{&OpEcho{content: "hello"}, &OpExec{cmd: "ls"}},
+		},
+		{
+			name:   "breath",
+			source: "BREATH",
+			want:   Script{&OpBreath{nl: true}},
+		},
+		{
+			name:   "run with sub ops",
+			source: "RUN vim\n- TYPE ihi\\e\n- BREATH\n- TYPE :x\\n\nRUN ls",
+			want: Script{
+				&OpExec{cmd: "vim", Ops: Script{
+					&OpType{content: "ihi\x1B"},
+					&OpBreath{},
+					&OpType{content: ":x\n"},
+				}},
+				&OpExec{cmd: "ls"},
+			},
+		},
+		{
+			name:   "snapshot",
+			source: "RUN vim\n- SNAPSHOT in-vim\nSNAPSHOT after_vim.1",
+			want: Script{
+				&OpExec{cmd: "vim", Ops: Script{&OpSnapshot{name: "in-vim"}}},
+				&OpSnapshot{name: "after_vim.1"},
+			},
+		},
+		{
+			name:   "expect",
+			source: "RUN false\n- EXPECT 1",
+			want:   Script{&OpExec{cmd: "false", expect: 1}},
+		},
+		{
+			name:   "output contains",
+			source: "RUN ls\n- OUTPUT-CONTAINS a\\tb\n- OUTPUT-CONTAINS c",
+			want: Script{&OpExec{cmd: "ls", checks: []OutputCheck{
+				&OutputContains{"a\tb"},
+				&OutputContains{"c"},
+			}}},
+		},
+		{
+			name:   "setup, rate and breath durations",
+			source: "SETUP cd /tmp\n- EXPECT 1\nRATE 8\nBREATH 2s\nRUN vim\n- BREATH 300ms",
+			want: Script{
+				&OpExec{cmd: "cd /tmp", setup: true, expect: 1},
+				&OpRate{rate: 8},
+				&OpBreath{nl: true, d: 2 * time.Second},
+				&OpExec{cmd: "vim", Ops: Script{&OpBreath{d: 300 * time.Millisecond}}},
+			},
+		},
+		{
+			name:   "notes and sections",
+			source: "SECTION Intro\nNOTE mention the weather\nRUN ls\n- TYPE x",
+			want: Script{
+				&OpSection{title: "Intro"},
+				&OpNote{content: "mention the weather"},
+				&OpExec{cmd: "ls", Ops: Script{&OpType{content: "x"}}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Parse(test.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			clearPos(got)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+// clearPos removes the positions from script so it can be compared with
+// literals.
+func clearPos(script Script) {
+	for _, op := range script {
+		*opPos(op) = Pos{}
+		if x, ok := op.(*OpExec); ok {
+			clearPos(x.Ops)
+		}
+	}
+}
+
+func TestParsePositions(t *testing.T) {
+	script, err := Parse("SAY hi\n\nSECTION Demo\n# comment\nRUN vim\n- TYPE x\n- NARRATION\nSECTION End\nBREATH")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []Pos
+	for _, op := range script {
+		got = append(got, *opPos(op))
+		if x, ok := op.(*OpExec); ok {
+			for _, sub := range x.Ops {
+				got = append(got, *opPos(sub))
+			}
+		}
+	}
+
+	want := []Pos{
+		{Line: 1},
+		{Line: 3, Section: "Demo"},
+		{Line: 5, Section: "Demo"},
+		{Line: 6, Section: "Demo"},
+		{Line: 8, Section: "End"},
+		{Line: 9, Section: "End"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if !script[2].(*OpExec).narration {
+		t.Errorf("expected the RUN to be marked as narration")
+	}
+}
+
+func TestParseOutputMatches(t *testing.T) {
+	script, err := Parse("RUN dig\n- OUTPUT-MATCHES ^x\\s+A$")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := script[0].(*OpExec)
+	if len(run.checks) != 1 {
+		t.Fatalf("expected 1 check, got %d", len(run.checks))
+	}
+
+	check := run.checks[0].(*OutputMatches)
+	if check.pattern != `^x\s+A$` {
+		t.Errorf("unexpected pattern %q", check.pattern)
+	}
+	if !check.re.MatchString("first\nx  A\nlast") {
+		t.Errorf("expected the pattern to match per line")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"unknown directive", "DANCE"},
+		{"lowercase", "say hello"},
+		{"say without text", "SAY "},
+		{"run without command", "RUN"},
+		{"sub op without run", "- TYPE x"},
+		{"sub op after say", "RUN ls\nSAY hi\n- TYPE x"},
+		{"unknown sub op", "RUN ls\n- DANCE"},
+		{"type without text", "RUN ls\n- TYPE "},
+		{"bad expect", "RUN ls\n- EXPECT one"},
+		{"expect out of range", "RUN ls\n- EXPECT 256"},
+		{"bad pattern", "RUN ls\n- OUTPUT-MATCHES ["},
+		{"snapshot without name", "SNAPSHOT "},
+		{"snapshot with path", "SNAPSHOT ../x"},
+		{"hidden snapshot", "SNAPSHOT .x"},
+		{"note without text", "NOTE "},
+		{"section without title", "SECTION"},
+		{"note as sub op", "RUN ls\n- NOTE x"},
+		{"bad rate", "RATE fast"},
+		{"zero rate", "RATE 0"},
+		{"bad breath", "BREATH 2"},
+		{"rate as sub op", "RUN ls\n- RATE 2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(test.source)
+			if err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	_, err := Parse("SAY hi\nRUN ls\n\n- DANCE")
+	if err == nil || err.Error() != "line 4: unable to interpret the script." {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestParseInclude(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.termp":         "SECTION Intro\nSAY hi\nINCLUDE parts/demo.termp\nSAY bye\n",
+		"parts/demo.termp":   "RUN ls\nSECTION Demo\nINCLUDE more.termp\n",
+		"parts/more.termp":   "\nSAY more\n",
+		"bad.termp":          "SAY ok\nINCLUDE parts/broken.termp\n",
+		"parts/broken.termp": "SAY ok\nDANCE\n",
+		"loop.termp":         "INCLUDE loop.termp\n",
+		"missing.termp":      "SAY ok\nINCLUDE nope.termp\n",
+	}
+	for name, source := range files {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	main := filepath.Join(dir, "main.termp")
+	script, read, err := parseFiles(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, op := range script {
+		p := opPos(op)
+		got = append(got, fmt.Sprintf("%s %s:%d %s", describe(op), filepath.Base(p.File), p.Line, p.Section))
+	}
+	want := []string{
+		"SECTION Intro .:1 Intro",
+		"SAY hi .:2 Intro",
+		"RUN ls demo.termp:1 Intro",
+		"SECTION Demo demo.termp:2 Demo",
+		"SAY more more.termp:2 Demo",
+		"SAY bye .:4 Demo",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if len(read) != 3 || read[0] != main {
+		t.Errorf("unexpected files %q", read)
+	}
+
+	_, err = ParseFile(filepath.Join(dir, "bad.termp"))
+	if err == nil || err.Error() != filepath.Join(dir, "parts/broken.termp")+":2: unable to interpret the script." {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = ParseFile(filepath.Join(dir, "loop.termp"))
+	if err == nil || err.Error() != filepath.Join(dir, "loop.termp")+":1: "+filepath.Join(dir, "loop.termp")+" includes itself." {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, read, err = parseFiles(filepath.Join(dir, "missing.termp"))
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Line != 2 || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(read) != 2 {
+		t.Errorf("expected the missing file to be watched too, got %q", read)
+	}
+}
+
+func TestReplaceEscapeSequences(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`plain`, "plain"},
+		{`a\nb`, "a\nb"},
+		{`\e:wq\n`, "\x1B:wq\n"},
+		{`\ESC`, "\x1B"},
+		{`\ETX`, "\x03"},
+		{`\t\v`, "\t\v"},
+		{`\\`, "\\"},
+		{`\NUL\US`, "\x00\x1F"},
+		{`\SO\SOH`, "\x0E\x01"},
+		{`\x41\x7f\xZZ`, "A\x7F\\xZZ"},
+		{`\u{2713}\u{110000}\u{}`, "\u2713\\u{110000}\\u{}"},
+		{`<Up>\<`, "<Up>\\<"},
+	}
+
+	for _, test := range tests {
+		got := replaceEscapeSequences(test.in)
+		if got != test.want {
+			t.Errorf("replaceEscapeSequences(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// visible makes the control characters in s readable, \e becomes <ESC>.
+func visible(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case int(r) < len(controlChars):
+			b.WriteString("<" + controlChars[r].name + ">")
+		case r == 0x7F:
+			b.WriteString("<DEL>")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// delay returns how long op takes to run when typing at rate, besides the
+// time taken by the commands it runs. It does not include the sub-ops of a
+// RUN.
+func delay(op Op, rate int) time.Duration {
+	if silent(op) {
+		return 0
+	}
+
+	d := opPause
+	switch x := op.(type) {
+	case *OpEcho:
+		d += typingTime("# "+x.content, nil, rate)
+	case *OpType:
+		d += typingTime(x.content, x.marks, rate)
+	case *OpExec:
+		d += typingTime(x.cmd, x.marks, rate) + enterPause
+		if len(x.Ops) > 0 {
+			d += subOpsPause
+		}
+	case *OpBreath:
+		if x.d != 0 {
+			d += x.d
+		} else {
+			d += breathPause
+		}
+	}
+	return d
+}
+
+// planLine describes op along with the visible typed text.
+func planLine(op Op) string {
+	switch x := op.(type) {
+	case *OpExec:
+		line := strings.Fields(describe(op))[0] + " " + visible(x.cmd)
+		if x.expect != 0 {
+			line += fmt.Sprintf(" (expects %d)", x.expect)
+		}
+		if x.narration {
+			line += " (narration)"
+		}
+		return line
+	case *OpType:
+		return "TYPE " + visible(x.content)
+	default:
+		return describe(op)
+	}
+}
+
+// WritePlan writes what running script would do, op by op, with the time
+// every op takes and an estimate of the total. Nothing is run.
+func WritePlan(w io.Writer, script Script) error {
+	type row struct {
+		pos, delay, op string
+	}
+
+	var (
+		rows  []row
+		total time.Duration
+		rate  = typingRate
+		width = len("line")
+	)
+
+	add := func(op Op, indent string) {
+		r := row{op: indent + planLine(op)}
+		if p := opPos(op); p != nil && p.Line > 0 {
+			r.pos = fmt.Sprint(p.Line)
+			if p.File != "" {
+				r.pos = p.File + ":" + r.pos
+			}
+		}
+		if r, ok := op.(*OpRate); ok {
+			rate = r.rate
+		}
+		if !silent(op) {
+			d := delay(op, rate)
+			total += d
+			r.delay = fmt.Sprintf("%.1fs", d.Seconds())
+		}
+		width = max(width, len(r.pos))
+		rows = append(rows, r)
+	}
+
+	for _, op := range script {
+		add(op, "")
+
+		x, ok := op.(*OpExec)
+		if !ok {
+			continue
+		}
+		for _, c := range x.checks {
+			switch c := c.(type) {
+			case *OutputContains:
+				rows = append(rows, row{op: "  - OUTPUT-CONTAINS " + visible(c.text)})
+			case *OutputMatches:
+				rows = append(rows, row{op: "  - OUTPUT-MATCHES " + c.pattern})
+			}
+		}
+		for _, sub := range x.Ops {
+			add(sub, "  - ")
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%*s  %6s  %s\n", width, "line", "delay", "op")
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		_, err = fmt.Fprintf(w, "%*s  %6s  %s\n", width, r.pos, r.delay, r.op)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "\nestimated runtime: %s, plus the time the commands take.\n", total.Round(100*time.Millisecond))
+	return err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVisible(t *testing.T) {
+	got := visible("iHello\x1B:wq\n\t\x7Fé")
+	want := "iHello<ESC>:wq<LF><HT><DEL>é"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWritePlan(t *testing.T) {
+	script, err := Parse(`SECTION Intro
+SAY hi
+RUN vim
+- TYPE ihello\e
+- BREATH
+RUN false
+- EXPECT 1
+- OUTPUT-CONTAINS ok
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = WritePlan(&out, script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `line   delay  op
+   1          SECTION Intro
+   2    0.5s  SAY hi
+   3    1.0s  RUN vim
+   4    0.7s    - TYPE ihello<ESC>
+   5    1.2s    - BREATH
+   6    0.7s  RUN false (expects 1)
+                - OUTPUT-CONTAINS ok
+
+estimated runtime: 4.1s, plus the time the commands take.
+`
+	if out.String() != want {
+		t.Errorf("unexpected plan:\n%s", out.String())
+	}
+}
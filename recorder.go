@@ -9,7 +9,9 @@ import (
 	"mime/multipart"
 	"net/http"
 	"os"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/creack/pty"
 	"github.com/vaughan0/go-ini"
@@ -23,6 +25,24 @@ type Recorder struct {
 	start time.Time
 	prev  time.Time
 	size  int
+
+	// asciicast v2
+	mu      sync.Mutex
+	width   int
+	height  int
+	events  []Event
+	partial []byte
+}
+
+// Event is an asciicast v2 event: "o" for output or "r" for a resize.
+type Event struct {
+	Time float64
+	Type string
+	Data string
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time, e.Type, e.Data})
 }
 
 type Timing struct {
@@ -77,6 +97,8 @@ func (r *Recorder) Write(p []byte) (int, error) {
 		return n, err
 	}
 
+	r.output(p)
+
 	n, err = r.buf.Write(p)
 	if err != nil {
 		return n, err
@@ -102,6 +124,108 @@ func (r *Recorder) Write(p []byte) (int, error) {
 	return n, err
 }
 
+// output adds an output event. Incomplete UTF-8 sequences at the end of p
+// are held back until the next write.
+func (r *Recorder) output(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.header()
+
+	var data []byte
+	data, r.partial = splitUTF8(r.partial, p)
+	if len(data) > 0 {
+		r.events = append(r.events, Event{r.elapsed(), "o", string(data)})
+	}
+}
+
+// splitUTF8 appends p to the held back partial and splits the result into
+// complete UTF-8 and a new incomplete sequence at the end.
+func splitUTF8(partial, p []byte) ([]byte, []byte) {
+	data := append(partial, p...)
+
+	end := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+
+	return data[:end], append([]byte(nil), data[end:]...)
+}
+
+// Resize records that the terminal changed its size.
+func (r *Recorder) Resize(rows, cols int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.header()
+
+	r.Meta.Term.Lines = rows
+	r.Meta.Term.Columns = cols
+	r.events = append(r.events, Event{r.elapsed(), "r", fmt.Sprintf("%dx%d", cols, rows)})
+}
+
+// header remembers the initial size of the terminal.
+func (r *Recorder) header() {
+	if r.width == 0 && r.height == 0 {
+		r.width = r.Meta.Term.Columns
+		r.height = r.Meta.Term.Lines
+	}
+}
+
+func (r *Recorder) elapsed() float64 {
+	return float64(time.Since(r.start)) / float64(time.Second)
+}
+
+// WriteCast writes the recording as an asciicast v2 file.
+func (r *Recorder) WriteCast(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.header()
+
+	header := struct {
+		Version   int               `json:"version"`
+		Width     int               `json:"width"`
+		Height    int               `json:"height"`
+		Timestamp int64             `json:"timestamp"`
+		Duration  float64           `json:"duration,omitempty"`
+		Title     string            `json:"title,omitempty"`
+		Env       map[string]string `json:"env,omitempty"`
+	}{
+		Version:   2,
+		Width:     r.width,
+		Height:    r.height,
+		Timestamp: r.start.Unix(),
+		Duration:  r.Meta.Duration,
+		Title:     r.Meta.Title,
+		Env: map[string]string{
+			"SHELL": r.Meta.Shell,
+			"TERM":  r.Meta.Term.Type,
+		},
+	}
+
+	enc := json.NewEncoder(w)
+
+	err := enc.Encode(header)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range r.events {
+		err := enc.Encode(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (r *Recorder) Flush() {
 	r.Meta.Duration = float64(time.Since(r.start)) / float64(time.Second)
 
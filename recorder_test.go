@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRecorderWriteCast(t *testing.T) {
+	var (
+		out bytes.Buffer
+		rec = NewRecorder(&out)
+	)
+	rec.Meta.Term.Type = "xterm"
+	rec.Meta.Term.Lines = 24
+	rec.Meta.Term.Columns = 80
+
+	rec.Write([]byte("h\xc3"))
+	rec.Write([]byte("\xa9llo\r\n"))
+	rec.Resize(30, 100)
+	rec.Write([]byte("bye"))
+
+	if out.String() != "héllo\r\nbye" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	if rec.Meta.Term.Lines != 30 || rec.Meta.Term.Columns != 100 {
+		t.Errorf("expected the meta data to be updated, got %+v", rec.Meta.Term)
+	}
+
+	var cast bytes.Buffer
+	err := rec.WriteCast(&cast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(cast.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected a header and 4 events, got %q", lines)
+	}
+
+	var header struct {
+		Version int
+		Width   int
+		Height  int
+		Env     map[string]string
+	}
+	err = json.Unmarshal([]byte(lines[0]), &header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 2 || header.Width != 80 || header.Height != 24 || header.Env["TERM"] != "xterm" {
+		t.Errorf("unexpected header %s", lines[0])
+	}
+
+	want := []struct{ typ, data string }{
+		{"o", "h"},
+		{"o", "éllo\r\n"},
+		{"r", "100x30"},
+		{"o", "bye"},
+	}
+	for i, w := range want {
+		var e []interface{}
+		err := json.Unmarshal([]byte(lines[i+1]), &e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(e) != 3 || e[1] != w.typ || e[2] != w.data {
+			t.Errorf("event %d: got %s, want %q %q", i, lines[i+1], w.typ, w.data)
+		}
+	}
+}
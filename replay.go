@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// OpReplay runs the commands of the part of a script which is skipped, as
+// fast as possible and without showing anything to the audience, so the
+// shell is in the state the rest of the script expects. RUNs marked as
+// NARRATION are left out, RATEs still apply.
+type OpReplay struct {
+	Pos
+	Ops Script
+}
+
+func (e *OpReplay) Exec(s *Session) error {
+	defer s.hide()()
+
+	for _, op := range e.Ops {
+		if r, ok := op.(*OpRate); ok {
+			r.Exec(s)
+			continue
+		}
+
+		x, ok := op.(*OpExec)
+		if !ok || x.narration {
+			continue
+		}
+
+		err := s.ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		_, err = x.exec(s, io.Discard)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return err
+			}
+			return fmt.Errorf("unable to replay line %d: %s", x.Line, err)
+		}
+	}
+
+	return nil
+}
+
+// Slice returns the part of the script from one op up to and including
+// another. Both are given as a line number or a section title; empty means
+// the start or the end of the script. The commands before the part are
+// replayed.
+func (s Script) Slice(from, to string) (Script, error) {
+	var (
+		start = 0
+		end   = len(s) - 1
+		err   error
+	)
+
+	if from != "" {
+		start, err = s.find(from, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if to != "" {
+		end, err = s.find(to, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if start > end {
+		return nil, errors.New("there is nothing to run between --from and --to.")
+	}
+
+	return s.slice(start, end), nil
+}
+
+// slice returns the ops from index start up to and including index end,
+// after replaying the ops before start.
+func (s Script) slice(start, end int) Script {
+	var out Script
+	if start > 0 {
+		out = append(out, &OpReplay{Ops: s[:start]})
+	}
+	return append(out, s[start:end+1]...)
+}
+
+// find returns the index of the first op at or after a line, or of the
+// last op when looking for the end. Lines are those of the script itself,
+// not of included files. For a section this is its SECTION or its last op.
+func (s Script) find(at string, last bool) (int, error) {
+	line, err := strconv.Atoi(at)
+	if err != nil {
+		return s.findSection(at, last)
+	}
+
+	if last {
+		for i := len(s) - 1; i >= 0; i-- {
+			if p := opPos(s[i]); p.File == "" && p.Line <= line {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("there is nothing before line %d.", line)
+	}
+
+	for i, op := range s {
+		if opPos(op).File == "" && lastLine(op) >= line {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("there is nothing at or after line %d.", line)
+}
+
+func (s Script) findSection(title string, last bool) (int, error) {
+	found := -1
+	for i, op := range s {
+		if !strings.EqualFold(opPos(op).Section, title) {
+			continue
+		}
+		if !last {
+			return i, nil
+		}
+		found = i
+	}
+
+	if found < 0 {
+		return 0, fmt.Errorf("unknown section %q.", title)
+	}
+	return found, nil
+}
+
+// lastLine returns the last line of op, including its sub ops.
+func lastLine(op Op) int {
+	line := opPos(op).Line
+	if x, ok := op.(*OpExec); ok {
+		for _, sub := range x.Ops {
+			line = max(line, lastLine(sub))
+		}
+	}
+	return line
+}
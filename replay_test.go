@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const replayScript = `SAY intro
+RUN cd /tmp
+SECTION Demo
+RUN echo narrated
+- NARRATION
+RUN vim
+- TYPE :q\n
+
+SAY middle
+SECTION End
+RUN echo bye
+`
+
+func TestScriptSlice(t *testing.T) {
+	script, err := Parse(replayScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		from, to string
+		want     []string
+	}{
+		{"", "", []string{"SAY intro", "RUN cd /tmp", "SECTION Demo", "RUN echo narrated", "RUN vim", "SAY middle", "SECTION End", "RUN echo bye"}},
+		{"demo", "", []string{"REPLAY 2 ops", "SECTION Demo", "RUN echo narrated", "RUN vim", "SAY middle", "SECTION End", "RUN echo bye"}},
+		{"", "Demo", []string{"SAY intro", "RUN cd /tmp", "SECTION Demo", "RUN echo narrated", "RUN vim", "SAY middle"}},
+		{"7", "9", []string{"REPLAY 4 ops", "RUN vim", "SAY middle"}},
+		{"8", "9", []string{"REPLAY 5 ops", "SAY middle"}},
+		{"8", "8", nil},
+		{"End", "11", []string{"REPLAY 6 ops", "SECTION End", "RUN echo bye"}},
+	}
+
+	for _, test := range tests {
+		got, err := script.Slice(test.from, test.to)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%q-%q: expected an error", test.from, test.to)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q-%q: unexpected error: %s", test.from, test.to, err)
+			continue
+		}
+
+		var ops []string
+		for _, op := range got {
+			ops = append(ops, describe(op))
+		}
+		if !reflect.DeepEqual(ops, test.want) {
+			t.Errorf("%q-%q: got %q, want %q", test.from, test.to, ops, test.want)
+		}
+	}
+}
+
+func TestScriptSliceErrors(t *testing.T) {
+	script, err := Parse(replayScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		from, to string
+		want     string
+	}{
+		{"nope", "", `unknown section "nope".`},
+		{"", "nope", `unknown section "nope".`},
+		{"12", "", "there is nothing at or after line 12."},
+		{"", "0", "there is nothing before line 0."},
+		{"End", "Demo", "there is nothing to run between --from and --to."},
+	}
+
+	for _, test := range tests {
+		_, err := script.Slice(test.from, test.to)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%q-%q: got %v, want %q", test.from, test.to, err, test.want)
+		}
+	}
+}
+
+func TestOpReplay(t *testing.T) {
+	script, err := Parse(replayScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err = script.Slice("End", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		pty = newFakePty(func(lines []string) (string, uint8, bool) {
+			switch lines[0] {
+			case "cd /tmp":
+				return "", 0, true
+			case "vim":
+				return "", 0, lines[len(lines)-1] == ":q"
+			}
+			return echoRun(lines)
+		})
+		out  bytes.Buffer
+		runs []string
+		log  eventLog
+	)
+
+	err = Exec(context.Background(), &out, script, Options{
+		Headless: true,
+		Shell:    &fakeShell{pty: pty},
+		Clock:    newFakeClock(),
+		Observer: &log,
+		OnRun:    func(r *RunResult) { runs = append(runs, r.Cmd) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	typed := pty.Typed()
+	if !strings.HasPrefix(typed, "cd /tmp\nvim\n:q\necho bye") || strings.Contains(typed, "narrated") {
+		t.Errorf("unexpected typed input %q", typed)
+	}
+	if strings.Contains(out.String(), "cd /tmp") {
+		t.Errorf("the replay was shown to the audience: %q", out.String())
+	}
+	if !reflect.DeepEqual(runs, []string{"echo bye"}) {
+		t.Errorf("unexpected runs %q", runs)
+	}
+	var shown string
+	for _, e := range log.events {
+		if e.Kind == EventType {
+			shown += e.Data
+		}
+	}
+	if shown != "echo bye" {
+		t.Errorf("unexpected typing events %q", shown)
+	}
+}
+
+func TestOpReplayModes(t *testing.T) {
+	s, _, _, out := newTestSession(echoRun)
+
+	err := (&OpReplay{Ops: Script{&OpExec{cmd: "echo \x1B[?1h"}}}).Exec(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.screen.AppCursor() {
+		t.Errorf("the screen missed application cursor keys while replaying")
+	}
+	if out.Len() != 0 || s.screen.Text() != "\n" {
+		t.Errorf("the replay was shown: %q, %q", out.String(), s.screen.Text())
+	}
+
+	err = (&OpExec{cmd: "echo \x1B[?1l", setup: true}).Exec(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.screen.AppCursor() {
+		t.Errorf("the screen missed normal cursor keys in a SETUP")
+	}
+	if out.Len() != 0 || s.screen.Text() != "\n" {
+		t.Errorf("the SETUP was shown: %q, %q", out.String(), s.screen.Text())
+	}
+}
+
+func TestOpReplayFailure(t *testing.T) {
+	script, err := Parse("RUN exit 3\nSAY hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err = script.Slice("2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Exec(context.Background(), &bytes.Buffer{}, script, Options{
+		Headless: true,
+		Shell:    &fakeShell{pty: newFakePty(echoRun)},
+		Clock:    newFakeClock(),
+	})
+	if err == nil || err.Error() != "unable to replay line 1: the command exited with status 3." {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
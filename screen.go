@@ -0,0 +1,476 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Screen is a minimal VT100 model. It keeps track of the visible characters
+// of everything written to it so the screen can be captured as plain text.
+type Screen struct {
+	mu     sync.Mutex
+	rows   int
+	cols   int
+	cells  [][]rune
+	saved  [][]rune
+	x, y   int
+	sx, sy int
+	wrap   bool
+	top    int
+	bottom int
+
+	// appCursor is set when the application asked for application
+	// cursor keys (DECCKM).
+	appCursor bool
+
+	state  int
+	params []byte
+	utf8   []byte
+}
+
+const (
+	screenGround = iota
+	screenEscape
+	screenCSI
+	screenOSC
+	screenOSCEscape
+	screenCharset
+)
+
+func NewScreen(rows, cols int) *Screen {
+	if rows <= 0 {
+		rows = 24
+	}
+	if cols <= 0 {
+		cols = 80
+	}
+
+	s := &Screen{rows: rows, cols: cols}
+	s.cells = s.blank()
+	s.top, s.bottom = 0, rows-1
+	return s
+}
+
+func (s *Screen) blank() [][]rune {
+	cells := make([][]rune, s.rows)
+	for i := range cells {
+		cells[i] = s.blankLine()
+	}
+	return cells
+}
+
+func (s *Screen) blankLine() []rune {
+	line := make([]rune, s.cols)
+	for i := range line {
+		line[i] = ' '
+	}
+	return line
+}
+
+// Resize changes the size of the screen. Like most terminals, rows are only
+// dropped from the top when the cursor would otherwise be off the screen.
+func (s *Screen) Resize(rows, cols int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rows <= 0 || cols <= 0 || (rows == s.rows && cols == s.cols) {
+		return
+	}
+
+	skip := max(s.y+1-rows, 0)
+
+	resize := func(cells [][]rune) [][]rune {
+		if cells == nil {
+			return nil
+		}
+
+		out := make([][]rune, rows)
+		for i := range out {
+			out[i] = make([]rune, cols)
+			for j := range out[i] {
+				out[i][j] = ' '
+			}
+			if i+skip < len(cells) {
+				copy(out[i], cells[i+skip])
+			}
+		}
+		return out
+	}
+
+	s.cells = resize(s.cells)
+	s.saved = resize(s.saved)
+	s.rows, s.cols = rows, cols
+	s.x = min(s.x, cols-1)
+	s.y -= skip
+	s.sx = min(s.sx, cols-1)
+	s.sy = clamp(s.sy-skip, 0, rows-1)
+	s.top, s.bottom = 0, rows-1
+	s.wrap = false
+}
+
+// Text returns the visible screen with trailing blanks removed.
+func (s *Screen) Text() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines := make([]string, len(s.cells))
+	for i, line := range s.cells {
+		lines[i] = strings.TrimRight(string(line), " ")
+	}
+
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Dump returns the escape sequences which draw the visible screen, without
+// attributes, on a terminal of the same size and put the cursor in place.
+func (s *Screen) Dump() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("\x1B[0m\x1B[H\x1B[2J")
+	for i, line := range s.cells {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(strings.TrimRight(string(line), " "))
+	}
+	b.WriteString("\x1B[" + strconv.Itoa(s.y+1) + ";" + strconv.Itoa(s.x+1) + "H")
+	return []byte(b.String())
+}
+
+// AppCursor reports whether the cursor keys send their application
+// sequences, like ESC O A for up.
+func (s *Screen) AppCursor() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appCursor
+}
+
+// Hidden returns a writer for output which is not shown. Nothing written to
+// it appears on s, but the modes it sets, like application cursor keys, are
+// followed.
+func (s *Screen) Hidden() io.Writer {
+	hidden := NewScreen(1, 1)
+	hidden.appCursor = s.AppCursor()
+	return &hiddenScreen{s, hidden}
+}
+
+type hiddenScreen struct {
+	screen, hidden *Screen
+}
+
+func (h *hiddenScreen) Write(p []byte) (int, error) {
+	h.hidden.Write(p)
+
+	h.screen.mu.Lock()
+	defer h.screen.mu.Unlock()
+	h.screen.appCursor = h.hidden.appCursor
+	return len(p), nil
+}
+
+func (s *Screen) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range p {
+		s.feed(c)
+	}
+
+	return len(p), nil
+}
+
+func (s *Screen) feed(c byte) {
+	switch s.state {
+
+	case screenGround:
+		if len(s.utf8) > 0 || c >= 0x80 {
+			s.utf8 = append(s.utf8, c)
+			if utf8.FullRune(s.utf8) {
+				r, _ := utf8.DecodeRune(s.utf8)
+				s.utf8 = s.utf8[:0]
+				s.put(r)
+			}
+			return
+		}
+
+		switch c {
+		case 0x1B:
+			s.state = screenEscape
+		case '\r':
+			s.x, s.wrap = 0, false
+		case '\n', '\v', '\f':
+			s.lineFeed()
+		case '\b':
+			if s.x > 0 {
+				s.x--
+			}
+			s.wrap = false
+		case '\t':
+			s.x = min((s.x/8+1)*8, s.cols-1)
+		default:
+			if c >= 0x20 && c != 0x7F {
+				s.put(rune(c))
+			}
+		}
+
+	case screenEscape:
+		s.state = screenGround
+		switch c {
+		case '[':
+			s.state = screenCSI
+			s.params = s.params[:0]
+		case ']':
+			s.state = screenOSC
+		case '(', ')', '*', '+', '#':
+			s.state = screenCharset
+		case '7':
+			s.sx, s.sy = s.x, s.y
+		case '8':
+			s.x, s.y, s.wrap = s.sx, s.sy, false
+		case 'D':
+			s.lineFeed()
+		case 'E':
+			s.x = 0
+			s.lineFeed()
+		case 'M':
+			s.reverseIndex()
+		case 'c':
+			s.cells, s.saved = s.blank(), nil
+			s.x, s.y, s.sx, s.sy, s.wrap = 0, 0, 0, 0, false
+			s.top, s.bottom = 0, s.rows-1
+			s.appCursor = false
+		}
+
+	case screenCharset:
+		s.state = screenGround
+
+	case screenOSC:
+		switch c {
+		case 0x07:
+			s.state = screenGround
+		case 0x1B:
+			s.state = screenOSCEscape
+		}
+
+	case screenOSCEscape:
+		s.state = screenGround
+
+	case screenCSI:
+		if c >= 0x40 && c <= 0x7E {
+			s.state = screenGround
+			s.csi(c)
+		} else {
+			s.params = append(s.params, c)
+		}
+
+	}
+}
+
+func (s *Screen) put(r rune) {
+	if s.wrap {
+		s.x = 0
+		s.wrap = false
+		s.lineFeed()
+	}
+
+	s.cells[s.y][s.x] = r
+
+	if s.x == s.cols-1 {
+		s.wrap = true
+	} else {
+		s.x++
+	}
+}
+
+func (s *Screen) lineFeed() {
+	s.wrap = false
+	if s.y == s.bottom {
+		s.scrollUp(1)
+	} else if s.y < s.rows-1 {
+		s.y++
+	}
+}
+
+func (s *Screen) reverseIndex() {
+	if s.y == s.top {
+		s.scrollDown(1)
+	} else if s.y > 0 {
+		s.y--
+	}
+}
+
+func (s *Screen) scrollUp(n int) {
+	for ; n > 0; n-- {
+		copy(s.cells[s.top:s.bottom], s.cells[s.top+1:s.bottom+1])
+		s.cells[s.bottom] = s.blankLine()
+	}
+}
+
+func (s *Screen) scrollDown(n int) {
+	for ; n > 0; n-- {
+		copy(s.cells[s.top+1:s.bottom+1], s.cells[s.top:s.bottom])
+		s.cells[s.top] = s.blankLine()
+	}
+}
+
+func (s *Screen) csi(final byte) {
+	var (
+		raw     = string(s.params)
+		private = strings.HasPrefix(raw, "?")
+		args    []int
+	)
+
+	raw = strings.TrimLeft(raw, "?>=")
+	if raw != "" {
+		for _, f := range strings.Split(raw, ";") {
+			n, _ := strconv.Atoi(f)
+			args = append(args, n)
+		}
+	}
+
+	// arg returns parameter i, or def when it is missing or not positive.
+	// Any program can write these, so they are capped at the size of the
+	// screen, which no movement or count needs to exceed.
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return min(args[i], max(s.rows, s.cols))
+		}
+		return def
+	}
+
+	s.wrap = false
+
+	switch final {
+	case 'A':
+		s.y = max(s.y-arg(0, 1), 0)
+	case 'B':
+		s.y = min(s.y+arg(0, 1), s.rows-1)
+	case 'C':
+		s.x = min(s.x+arg(0, 1), s.cols-1)
+	case 'D':
+		s.x = max(s.x-arg(0, 1), 0)
+	case 'E':
+		s.x, s.y = 0, min(s.y+arg(0, 1), s.rows-1)
+	case 'F':
+		s.x, s.y = 0, max(s.y-arg(0, 1), 0)
+	case 'G', '`':
+		s.x = clamp(arg(0, 1)-1, 0, s.cols-1)
+	case 'd':
+		s.y = clamp(arg(0, 1)-1, 0, s.rows-1)
+	case 'H', 'f':
+		s.y = clamp(arg(0, 1)-1, 0, s.rows-1)
+		s.x = clamp(arg(1, 1)-1, 0, s.cols-1)
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			s.clear(s.y, s.x, s.rows-1, s.cols-1)
+		case 1:
+			s.clear(0, 0, s.y, s.x)
+		case 2, 3:
+			s.clear(0, 0, s.rows-1, s.cols-1)
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			s.clear(s.y, s.x, s.y, s.cols-1)
+		case 1:
+			s.clear(s.y, 0, s.y, s.x)
+		case 2:
+			s.clear(s.y, 0, s.y, s.cols-1)
+		}
+	case 'X':
+		s.clear(s.y, s.x, s.y, min(s.x+arg(0, 1), s.cols)-1)
+	case 'P':
+		line := s.cells[s.y]
+		n := min(arg(0, 1), s.cols-s.x)
+		copy(line[s.x:], line[s.x+n:])
+		s.clear(s.y, s.cols-n, s.y, s.cols-1)
+	case '@':
+		line := s.cells[s.y]
+		n := min(arg(0, 1), s.cols-s.x)
+		copy(line[s.x+n:], line[s.x:])
+		s.clear(s.y, s.x, s.y, s.x+n-1)
+	case 'L':
+		if s.y >= s.top && s.y <= s.bottom {
+			top := s.top
+			s.top = s.y
+			s.scrollDown(min(arg(0, 1), s.rows))
+			s.top = top
+		}
+	case 'M':
+		if s.y >= s.top && s.y <= s.bottom {
+			top := s.top
+			s.top = s.y
+			s.scrollUp(min(arg(0, 1), s.rows))
+			s.top = top
+		}
+	case 'S':
+		s.scrollUp(min(arg(0, 1), s.rows))
+	case 'T':
+		s.scrollDown(min(arg(0, 1), s.rows))
+	case 'r':
+		top, bottom := arg(0, 1)-1, arg(1, s.rows)-1
+		if top >= 0 && top < bottom && bottom < s.rows {
+			s.top, s.bottom = top, bottom
+			s.x, s.y = 0, 0
+		}
+	case 's':
+		s.sx, s.sy = s.x, s.y
+	case 'u':
+		s.x, s.y = s.sx, s.sy
+	case 'h', 'l':
+		if private {
+			for _, mode := range args {
+				s.setMode(mode, final == 'h')
+			}
+		}
+	}
+}
+
+func (s *Screen) setMode(mode int, on bool) {
+	switch mode {
+	case 1:
+		s.appCursor = on
+	case 47, 1047, 1049:
+		if on && s.saved == nil {
+			s.saved = s.cells
+			s.cells = s.blank()
+			if mode == 1049 {
+				s.sx, s.sy = s.x, s.y
+			}
+		} else if !on && s.saved != nil {
+			s.cells = s.saved
+			s.saved = nil
+			if mode == 1049 {
+				s.x, s.y = s.sx, s.sy
+			}
+		}
+	}
+}
+
+func (s *Screen) clear(y0, x0, y1, x1 int) {
+	for y := y0; y <= y1; y++ {
+		from, to := 0, s.cols-1
+		if y == y0 {
+			from = x0
+		}
+		if y == y1 {
+			to = x1
+		}
+		for x := from; x <= to && x < s.cols; x++ {
+			s.cells[y][x] = ' '
+		}
+	}
+}
+
+func clamp(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
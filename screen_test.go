@@ -0,0 +1,114 @@
+package main
+
+import "testing"
+
+func TestScreen(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty", "", "\n"},
+		{"text", "hello\r\nworld", "hello\nworld\n"},
+		{"colors", "\x1B[1;32mok\x1B[0m", "ok\n"},
+		{"carriage return", "abc\rX", "Xbc\n"},
+		{"backspace", "abc\b\bX", "aXc\n"},
+		{"tab", "a\tb", "a       b\n"},
+		{"wrap", "0123456789abc", "0123456789\nabc\n"},
+		{"no wrap at margin", "0123456789\r\nx", "0123456789\nx\n"},
+		{"scroll", "1\r\n2\r\n3\r\n4\r\n5", "2\n3\n4\n5\n"},
+		{"cursor position", "\x1B[2;3Hx", "\n  x\n"},
+		{"erase display", "abc\r\ndef\x1B[2J", "\n"},
+		{"erase line", "abcdef\x1B[3D\x1B[K", "abc\n"},
+		{"delete chars", "abcdef\r\x1B[2P", "cdef\n"},
+		{"insert chars", "abcdef\r\x1B[2@", "  abcdef\n"},
+		{"utf8", "h\xc3\xa9llo", "héllo\n"},
+		{"osc title", "\x1B]0;title\x07x", "x\n"},
+		{"charset", "\x1B(Bx", "x\n"},
+		{"alternate screen", "shell\x1B[?1049h\x1B[Hvim\x1B[?1049l", "shell\n"},
+		{"reverse index", "\x1B[H\x1BMx", "x\n"},
+		{"negative delete", "abc\r\x1B[-5P", "bc\n"},
+		{"negative insert", "abc\r\x1B[-5@", " abc\n"},
+		{"negative region", "\x1B[-5;10r1\r\n2\r\n3\r\n4\r\n5", "2\n3\n4\n5\n"},
+		{"empty region", "\x1B[3;3r1\r\n2\r\n3\r\n4\r\n5", "2\n3\n4\n5\n"},
+		{"huge delete", "abc\r\x1B[99999999999999999999P", "\n"},
+		{"huge insert", "abc\r\x1B[99999999999@x", "x\n"},
+		{"huge scroll", "a\r\nb\x1B[99999999999S\x1B[99999999999T", "\n"},
+		{"huge insert lines", "a\r\nb\x1B[H\x1B[99999999999L\x1B[99999999999M", "\n"},
+		{"huge cursor movement", "\x1B[99999999999C\x1B[99999999999Bx\x1B[-3Ay", "\n\n         y\n         x\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewScreen(4, 10)
+			s.Write([]byte(test.input))
+
+			if got := s.Text(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScreenSplitUTF8(t *testing.T) {
+	s := NewScreen(4, 10)
+	s.Write([]byte("\xc3"))
+	s.Write([]byte("\xa9"))
+
+	if got := s.Text(); got != "é\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestScreenResize(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		rows, cols int
+		after      string
+		want       string
+	}{
+		{"grow", "ab\r\ncd", 6, 20, "ef", "ab\ncdef\n"},
+		{"narrow", "abcdef\r\ngh", 4, 3, "ij", "abc\nghi\nj\n"},
+		{"shrink keeps the top", "a\r\nb", 2, 10, "c", "a\nbc\n"},
+		{"shrink follows the cursor", "a\r\nb\r\nc\r\nd", 2, 10, "e", "c\nde\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewScreen(4, 10)
+			s.Write([]byte(test.input))
+			s.Resize(test.rows, test.cols)
+			s.Write([]byte(test.after))
+
+			if got := s.Text(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScreenDump(t *testing.T) {
+	s := NewScreen(3, 10)
+	s.Write([]byte("\x1B[1mab\x1B[0m\r\ncd\x1B[3;5Hx\x1B[3;2H"))
+
+	got := string(s.Dump())
+	want := "\x1B[0m\x1B[H\x1B[2Jab\r\ncd\r\n    x\x1B[3;2H"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	replay := NewScreen(3, 10)
+	replay.Write([]byte(got))
+	if replay.Text() != s.Text() {
+		t.Errorf("replay %q, want %q", replay.Text(), s.Text())
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	got := lineDiff("a", "b", "one\ntwo\nthree\n", "one\n2\nthree\nfour\n")
+	want := "--- a\n+++ b\n  one\n- two\n+ 2\n  three\n+ four\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExportShell converts a script into a bash script which runs its commands.
+// Like the script, it stops at the first command which does not exit as
+// expected. What the script types into commands is left to the reader, it
+// is printed before the command starts. The output of commands with OUTPUT
+// checks is captured into a file to check, and printed afterwards.
+func ExportShell(script Script, name string) string {
+	lines := []string{
+		"#!/usr/bin/env bash",
+		"# The commands of " + name + ", it stops at the first one which fails.",
+		"set -e",
+	}
+	for _, op := range script {
+		if x, ok := op.(*OpExec); ok && len(x.checks) > 0 {
+			lines = append(lines, `out=$(mktemp)`, `trap 'rm -f "$out"' EXIT`)
+			break
+		}
+	}
+
+	var setup bool
+	for _, op := range script {
+		switch x := op.(type) {
+		case *OpSection:
+			lines = append(lines, "", "### "+x.title)
+		case *OpEcho:
+			lines = append(lines, "# "+strings.ReplaceAll(x.content, "\n", "\n# "))
+		case *OpExec:
+			if x.setup && !setup {
+				lines = append(lines, "# Setup, not shown in the demo.")
+			}
+			lines = append(lines, shellRun(x)...)
+		}
+
+		x, ok := op.(*OpExec)
+		setup = ok && x.setup
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// shellRun returns the lines which run x and check how it exited.
+func shellRun(x *OpExec) []string {
+	var lines []string
+
+	var typed string
+	for _, sub := range x.Ops {
+		if y, ok := sub.(*OpType); ok {
+			typed += readableKeys(y.content)
+		}
+	}
+	if typed != "" {
+		msg := "Manual step, type into " + commandName(x.cmd) + ": " + typed
+		lines = append(lines, "echo "+shellQuote(msg)+" >&2")
+	}
+
+	if x.expect == 0 && len(x.checks) == 0 {
+		return append(lines, x.cmd)
+	}
+
+	// The command goes on lines of its own, a trailing comment, & or a here
+	// document would swallow the braces otherwise.
+	lines = append(lines, "status=0", "{", x.cmd)
+	if len(x.checks) == 0 {
+		lines = append(lines, "} || status=$?")
+	} else {
+		lines = append(lines, `} >"$out" 2>&1 || status=$?`, `cat "$out"`)
+	}
+	lines = append(lines, fmt.Sprintf(`[ $status -eq %d ] || { echo "expected exit status %d, got $status." >&2; exit 1; }`, x.expect, x.expect))
+
+	fail := func(msg string) string {
+		return "{ echo " + shellQuote(msg) + " >&2; exit 1; }"
+	}
+	for _, c := range x.checks {
+		switch c := c.(type) {
+		case *OutputContains:
+			lines = append(lines, `[[ $(<"$out") == *`+shellString(c.text)+`* ]] || `+fail("the output does not contain "+readableKeys(c.text)+"."))
+		case *OutputMatches:
+			lines = append(lines, `grep -qE -e `+shellString(c.pattern)+` "$out" || `+fail("the output does not match /"+c.pattern+"/."))
+		}
+	}
+
+	return lines
+}
+
+// shellQuote quotes s as a single word for bash.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellString quotes s like shellQuote, but control characters are escaped
+// with ANSI-C quoting so they stay readable.
+func shellString(s string) string {
+	if !strings.ContainsFunc(s, func(r rune) bool { return r < ' ' || r == 0x7F }) {
+		return shellQuote(s)
+	}
+
+	var b strings.Builder
+	b.WriteString("$'")
+	for _, r := range s {
+		switch {
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == 0x1B:
+			b.WriteString(`\e`)
+		case r == '\'' || r == '\\':
+			b.WriteString(`\` + string(r))
+		case r < ' ' || r == 0x7F:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestExportShell(t *testing.T) {
+	script, err := Parse(`SETUP cd /tmp
+SECTION Intro
+SAY it's a demo
+RUN vim
+- TYPE ihi\e:x\n
+- BREATH
+- TYPE it's
+RUN false
+- EXPECT 1
+- OUTPUT-CONTAINS \e[1m
+RUN echo hi # greet
+- EXPECT 0
+- OUTPUT-MATCHES ^h.$
+NOTE private
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := ExportShell(script, "demo.termp")
+	want := `#!/usr/bin/env bash
+# The commands of demo.termp, it stops at the first one which fails.
+set -e
+out=$(mktemp)
+trap 'rm -f "$out"' EXIT
+# Setup, not shown in the demo.
+cd /tmp
+
+### Intro
+# it's a demo
+echo 'Manual step, type into vim: ihi<Esc>:x<Enter>it'\''s' >&2
+vim
+status=0
+{
+false
+} >"$out" 2>&1 || status=$?
+cat "$out"
+[ $status -eq 1 ] || { echo "expected exit status 1, got $status." >&2; exit 1; }
+[[ $(<"$out") == *$'\e[1m'* ]] || { echo 'the output does not contain <Esc>[1m.' >&2; exit 1; }
+status=0
+{
+echo hi # greet
+} >"$out" 2>&1 || status=$?
+cat "$out"
+[ $status -eq 0 ] || { echo "expected exit status 0, got $status." >&2; exit 1; }
+grep -qE -e '^h.$' "$out" || { echo 'the output does not match /^h.$/.' >&2; exit 1; }
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/creack/pty"
+)
+
+// Shell starts the instrumented shell a script is executed in. The shell
+// must print the markers described in marker.go using nonce.
+type Shell interface {
+	Start(rows, cols int, nonce string) (Pty, error)
+}
+
+// Pty is the terminal connected to a running shell.
+type Pty interface {
+	io.ReadWriter
+
+	// Mirror copies the terminal modes of the shell to the audience
+	// terminal.
+	Mirror() error
+
+	// AwaitPrompt waits a little while for readline to take over the
+	// terminal after a prompt. Keys typed before are echoed twice, by the
+	// terminal and again by readline.
+	AwaitPrompt()
+
+	// Resize changes the size of the terminal.
+	Resize(rows, cols int) error
+
+	// Close ends the shell and restores the terminal.
+	Close() error
+
+	// Kill ends the shell and everything running in it without waiting
+	// for the current command. Close must still be called.
+	Kill() error
+}
+
+// BashShell runs bash on a real pty. When Stdin is set its terminal modes
+// follow those of the pty. Vars, given as NAME=value, are set in bash after
+// the variables of the markers.
+type BashShell struct {
+	Stdin *os.File
+	Vars  []string
+}
+
+func (b *BashShell) Start(rows, cols int, nonce string) (Pty, error) {
+	vars := append(markerVars(nonce), "PS2=", "PS3=", "PS4=")
+	vars = append(vars, b.Vars...)
+
+	// The variables carry the nonce. They are set by an rc file bash reads
+	// from a pipe rather than in its environment, so they are not exported
+	// to the commands of the script.
+	rc, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	_, err = io.WriteString(w, bashRC(vars))
+	w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("bash", "--noprofile", "--rcfile", "/dev/fd/3")
+	cmd.ExtraFiles = []*os.File{rc}
+
+	p := &bashPty{cmd: cmd, exited: make(chan struct{})}
+
+	if b.Stdin != nil {
+		state, err := newPtyState(b.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		p.stdin = b.Stdin
+		p.stdinState = state
+	}
+
+	f, err := pty.StartWithSize(cmd, &pty.Winsize{
+		Rows: uint16(rows),
+		Cols: uint16(cols),
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.File = f
+
+	go func() {
+		cmd.Wait()
+		close(p.exited)
+	}()
+
+	state, err := newPtyState(f)
+	if err != nil {
+		p.Kill()
+		p.Close()
+		return nil, err
+	}
+	p.state = state
+
+	return p, nil
+}
+
+// bashRC returns the rc file which sets vars without exporting them, read
+// from file descriptor 3.
+func bashRC(vars []string) string {
+	var b strings.Builder
+	for _, v := range vars {
+		name, value, _ := strings.Cut(v, "=")
+		b.WriteString("declare +x " + name + "=" + shellQuote(value) + "\n")
+	}
+	b.WriteString("exec 3<&-\n")
+	return b.String()
+}
+
+type bashPty struct {
+	*os.File
+	cmd        *exec.Cmd
+	exited     chan struct{}
+	state      *PtyState
+	stdin      *os.File
+	stdinState *PtyState
+}
+
+func (p *bashPty) Mirror() error {
+	if p.stdin == nil {
+		return nil
+	}
+	return p.state.CopyTo(p.stdin)
+}
+
+// AwaitPrompt waits until readline switched the terminal out of canonical
+// mode, for up to a second.
+func (p *bashPty) AwaitPrompt() {
+	deadline := time.Now().Add(time.Second)
+
+	for time.Now().Before(deadline) {
+		var state syscall.Termios
+		if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL,
+			p.Fd(),
+			ioctlReadTermios,
+			uintptr(unsafe.Pointer(&state)),
+			0, 0, 0); err != 0 || state.Lflag&syscall.ICANON == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (p *bashPty) Resize(rows, cols int) error {
+	return pty.Setsize(p.File, &pty.Winsize{
+		Rows: uint16(rows),
+		Cols: uint16(cols),
+	})
+}
+
+func (p *bashPty) Close() error {
+	var err error
+
+	if p.state != nil {
+		p.state.Restore()
+	}
+
+	select {
+	case <-p.exited:
+	default:
+		_, err = p.Write([]byte{4})
+	}
+
+	// The pty is only closed once bash is gone, so it never reads from a
+	// closed terminal.
+	select {
+	case <-p.exited:
+	case <-time.After(time.Second):
+		p.Kill()
+	}
+	p.File.Close()
+
+	if p.stdinState != nil {
+		p.stdinState.Restore()
+	}
+
+	return err
+}
+
+// Kill hangs up on bash and the job in the foreground and forcefully kills
+// them when they are still around after a second.
+func (p *bashPty) Kill() error {
+	groups := []int{p.cmd.Process.Pid}
+
+	var fg int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL,
+		p.Fd(),
+		syscall.TIOCGPGRP,
+		uintptr(unsafe.Pointer(&fg)))
+	if errno == 0 && int(fg) != groups[0] {
+		groups = append(groups, int(fg))
+	}
+
+	for _, pgid := range groups {
+		syscall.Kill(-pgid, syscall.SIGHUP)
+		syscall.Kill(-pgid, syscall.SIGCONT)
+	}
+
+	select {
+	case <-p.exited:
+	case <-time.After(time.Second):
+		for _, pgid := range groups {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		}
+	}
+
+	return nil
+}
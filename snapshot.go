@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Snapshots are taken on a screen of this size, whatever the size of the
+// terminal, so that they compare equal wherever they were taken.
+const snapshotRows, snapshotCols = 24, 80
+
+// Snapshots checks the screens of SNAPSHOTs against golden files, or
+// updates those. SNAPSHOT does nothing when neither is asked for.
+type Snapshots struct {
+	Dir    string
+	Check  bool
+	Update bool
+	failed int
+}
+
+// For returns the snapshots of the script name, a relative Dir is relative
+// to the directory of the script.
+func (s *Snapshots) For(name string) *Snapshots {
+	dir := s.Dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(name), dir)
+	}
+	return &Snapshots{Dir: dir, Check: s.Check, Update: s.Update}
+}
+
+// Active reports whether SNAPSHOTs are checked or updated.
+func (s *Snapshots) Active() bool {
+	return s != nil && (s.Check || s.Update)
+}
+
+// Failed reports whether any snapshot did not match its golden file.
+func (s *Snapshots) Failed() bool {
+	return s.failed > 0
+}
+
+func (s *Snapshots) path(name string) string {
+	return filepath.Join(s.Dir, name+".txt")
+}
+
+func (s *Snapshots) Save(name, text string) error {
+	if !s.Update {
+		if s.Check {
+			return s.compare(name, text)
+		}
+		return nil
+	}
+
+	err := os.MkdirAll(s.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.path(name), []byte(text), 0644)
+}
+
+func (s *Snapshots) compare(name, text string) error {
+	golden, err := os.ReadFile(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		s.failed++
+		return fmt.Errorf("snapshot %q is missing (%s).", name, s.path(name))
+	}
+	if err != nil {
+		return err
+	}
+
+	if string(golden) == text {
+		return nil
+	}
+
+	s.failed++
+	return fmt.Errorf("snapshot %q does not match:\n%s", name,
+		lineDiff(s.path(name), "screen", string(golden), text))
+}
+
+// lineDiff renders a unified-style diff (without hunks) of a and b.
+func lineDiff(nameA, nameB, a, b string) string {
+	var (
+		x   = strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+		y   = strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+		lcs = make([][]int, len(x)+1)
+		out strings.Builder
+	)
+
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			fmt.Fprintf(&out, "  %s\n", x[i])
+			i, j = i+1, j+1
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&out, "- %s\n", x[i])
+			i++
+		default:
+			fmt.Fprintf(&out, "+ %s\n", y[j])
+			j++
+		}
+	}
+
+	return out.String()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Summary describes how far a script got.
+type Summary struct {
+	Ops      int
+	Executed int
+	Duration time.Duration
+
+	// Failed is the op which failed or was interrupted and Last is the
+	// result of the last RUN.
+	Failed Op
+	Last   *RunResult
+	Err    error
+}
+
+func (m *Summary) fill(s *Session, op Op, err error, d time.Duration) {
+	m.Ops = 1
+	if script, ok := op.(Script); ok {
+		m.Ops = len(script)
+	}
+
+	m.Executed = s.executed
+	m.Duration = d
+	m.Last = s.last
+	m.Err = err
+	m.Failed = nil
+	if err != nil {
+		m.Failed = s.current
+	}
+}
+
+func (m *Summary) Report(w io.Writer) {
+	fmt.Fprintf(w, "ops:      %d of %d executed\n", m.Executed, m.Ops)
+
+	if m.Failed != nil {
+		fmt.Fprintf(w, "failed:   %s\n", describe(m.Failed))
+		if p := opPos(m.Failed); p != nil && p.Line > 0 {
+			if p.File != "" {
+				fmt.Fprintf(w, "line:     %d of %s\n", p.Line, p.File)
+			} else {
+				fmt.Fprintf(w, "line:     %d\n", p.Line)
+			}
+		}
+		if x, ok := m.Failed.(*OpExec); ok && m.Last != nil && m.Last.Cmd == x.cmd {
+			fmt.Fprintf(w, "status:   %d\n", m.Last.Status)
+		}
+	}
+
+	if m.Err != nil {
+		fmt.Fprintf(w, "error:    %s\n", m.Err)
+	}
+
+	fmt.Fprintf(w, "duration: %s\n", m.Duration.Round(time.Millisecond))
+}
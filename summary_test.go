@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestSummary(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"success", "SAY hi\nRUN echo hello", "ops:      2 of 2 executed\nduration: 1.475s\n"},
+		{"failure", "SAY hi\nRUN exit 3\nRUN echo skipped", "ops:      1 of 3 executed\nfailed:   RUN exit 3\nline:     2\nstatus:   3\nerror:    the command exited with status 3.\nduration: 3.413s\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := Parse(test.script)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var summary Summary
+			Exec(context.Background(), &bytes.Buffer{}, script, Options{
+				Headless: true,
+				Shell:    &fakeShell{pty: newFakePty(echoRun)},
+				Clock:    newFakeClock(),
+				Summary:  &summary,
+			})
+
+			var out bytes.Buffer
+			summary.Report(&out)
+			if got := out.String(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Conversion between scripts and the tapes of charmbracelet's VHS. Both
+// type into a terminal, but a tape does not know where a command ends, so
+// the input after a command is only taken to be for the command itself
+// when it is an interactive program like vim or less.
+
+// tapeFields splits a line of a tape into words and quoted strings.
+func tapeFields(line string) ([]string, error) {
+	var fields []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return fields, nil
+		}
+
+		switch q := line[0]; q {
+		case '"', '\'', '`':
+			end := strings.IndexByte(line[1:], q)
+			if end < 0 {
+				return nil, errors.New("unterminated string.")
+			}
+			fields = append(fields, line[1:end+1])
+			line = line[end+2:]
+		default:
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			fields = append(fields, line[:end])
+			line = line[end:]
+		}
+	}
+}
+
+// tapeDuration parses durations like 500ms, or seconds without a unit.
+func tapeDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err == nil && d > 0 {
+		return d, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || secs <= 0 {
+		return 0, fmt.Errorf("invalid duration %q.", s)
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// tapeKeys are the keys of VHS along with what they send.
+var tapeKeys = map[string]string{
+	"Enter":     "\n",
+	"Tab":       "\t",
+	"Space":     " ",
+	"Backspace": "\b",
+	"Escape":    "\x1B",
+	"Up":        "\x1B[A",
+	"Down":      "\x1B[B",
+	"Right":     "\x1B[C",
+	"Left":      "\x1B[D",
+	"Home":      "\x1B[H",
+	"End":       "\x1B[F",
+	"Insert":    "\x1B[2~",
+	"Delete":    "\x1B[3~",
+	"PageUp":    "\x1B[5~",
+	"PageDown":  "\x1B[6~",
+}
+
+// tapeKey returns what a key like Ctrl+C or Alt+x sends.
+func tapeKey(name string) (string, bool) {
+	if seq, ok := tapeKeys[name]; ok {
+		return seq, true
+	}
+
+	mod, key, ok := strings.Cut(name, "+")
+	if !ok || len(key) != 1 {
+		if name == "Shift+Tab" {
+			return "\x1B[Z", true
+		}
+		return "", false
+	}
+
+	switch mod {
+	case "Ctrl":
+		c := strings.ToUpper(key)[0]
+		if c < '@' || c > '_' {
+			return "", false
+		}
+		return string(rune(c - '@')), true
+	case "Alt":
+		return "\x1B" + key, true
+	case "Shift":
+		return strings.ToUpper(key), true
+	}
+	return "", false
+}
+
+// interactive are programs which read the input typed after them, until
+// what is typed looks like it quits them.
+var interactive = map[string]*regexp.Regexp{}
+
+func init() {
+	var (
+		editor = regexp.MustCompile(`(^|[\n\x1B]):(w?q|x|w?qa)!?\n$|Z[ZQ]$`)
+		pager  = regexp.MustCompile(`(^|\n)[^/?:\n]*q$`)
+		repl   = regexp.MustCompile(`(^|\n)(exit|quit|exit\(\)|quit\(\)|\\q|\.quit|\.exit|logout)\n$|\x04$`)
+	)
+	for _, name := range []string{"vi", "vim", "nvim", "view"} {
+		interactive[name] = editor
+	}
+	for _, name := range []string{"less", "more", "man", "top", "htop", "tig"} {
+		interactive[name] = pager
+	}
+	for _, name := range []string{"python", "python3", "node", "irb", "psql", "mysql", "sqlite3", "ssh"} {
+		interactive[name] = repl
+	}
+}
+
+type tapeImporter struct {
+	script   Script
+	warnings []Warning
+	hidden   bool
+	rate     int
+
+	// At the prompt.
+	line   string
+	lineAt int
+	edited bool // with keys which are not followed, line is not what runs
+
+	// In an interactive program.
+	run     *OpExec
+	quits   *regexp.Regexp
+	input   string // everything typed into the program
+	typed   string // typed, but not added as a TYPE yet
+	typedAt int
+}
+
+// ImportTape converts a VHS tape into a script. Everything the script can
+// not express is reported.
+func ImportTape(source string) (Script, []Warning) {
+	t := &tapeImporter{rate: typingRate}
+	for i, line := range strings.Split(source, "\n") {
+		t.command(i+1, strings.TrimSpace(line))
+	}
+	return t.finish()
+}
+
+func (t *tapeImporter) finish() (Script, []Warning) {
+	t.flush()
+	if strings.TrimSpace(t.line) != "" {
+		t.warn(t.lineAt, "%q is typed, but never entered.", t.line)
+	}
+	return t.script, t.warnings
+}
+
+func (t *tapeImporter) warn(at int, format string, args ...any) {
+	t.warnings = append(t.warnings, Warning{Pos: Pos{Line: at}, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (t *tapeImporter) add(at int, op Op) {
+	*opPos(op) = Pos{Line: at}
+	if t.run != nil {
+		t.flush()
+		t.run.Ops = append(t.run.Ops, op)
+	} else {
+		t.script = append(t.script, op)
+	}
+}
+
+// flush adds what was typed into the program as a TYPE.
+func (t *tapeImporter) flush() {
+	if t.typed == "" {
+		return
+	}
+	t.run.Ops = append(t.run.Ops, &OpType{Pos: Pos{Line: t.typedAt}, content: t.typed})
+	t.typed = ""
+}
+
+func (t *tapeImporter) send(at int, s string) {
+	if t.run == nil {
+		t.prompt(at, s)
+		return
+	}
+
+	if t.typed == "" {
+		t.typedAt = at
+	}
+	t.typed += s
+	t.input += s
+
+	if t.quits.MatchString(t.input) {
+		t.flush()
+		t.run = nil
+	}
+}
+
+// prompt handles keys typed at the prompt of the shell. Only typing and
+// erasing are followed, a command edited with other keys, like the cursor
+// keys, completion or searching the history, is dropped.
+func (t *tapeImporter) prompt(at int, s string) {
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if r == '\x1B' {
+			n = escapeLen(s[i:])
+		}
+		i += n
+
+		switch r {
+		case '\n':
+			cmd := strings.TrimSpace(t.line)
+			edited := t.edited
+			t.line, t.edited = "", false
+			if edited {
+				if cmd == "" {
+					t.warn(at, "a command from the history is dropped.")
+				} else {
+					t.warn(at, "%q is dropped, it was edited with keys which are not supported.", cmd)
+				}
+				continue
+			}
+			if cmd == "" {
+				continue
+			}
+
+			// A comment at the prompt is what a SAY looks like.
+			if strings.HasPrefix(cmd, "#") && !t.hidden {
+				t.script = append(t.script, &OpEcho{Pos: Pos{Line: t.lineAt}, content: strings.TrimSpace(cmd[1:])})
+				continue
+			}
+
+			op := &OpExec{Pos: Pos{Line: t.lineAt}, cmd: cmd, setup: t.hidden}
+			t.script = append(t.script, op)
+			if quits, ok := interactive[commandName(cmd)]; ok {
+				t.run, t.quits, t.input = op, quits, ""
+			}
+		case '\b', 0x7F:
+			_, n := utf8.DecodeLastRuneInString(t.line)
+			t.line = t.line[:len(t.line)-n]
+		case '\x03':
+			t.line, t.edited = "", false
+		default:
+			if r < ' ' || r == '\x1B' {
+				t.warn(at, "%s at the prompt is not supported.", visible(s[i-n:i]))
+				t.edited = true
+				continue
+			}
+			if t.line == "" {
+				t.lineAt = at
+			}
+			t.line += string(r)
+		}
+	}
+}
+
+// escapeLen returns the length of the escape sequence at the start of s.
+func escapeLen(s string) int {
+	switch {
+	case strings.HasPrefix(s, "\x1B["):
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7E {
+				return i + 1
+			}
+		}
+		return len(s)
+	case strings.HasPrefix(s, "\x1BO") && len(s) > 2:
+		return 3
+	default:
+		return 1
+	}
+}
+
+func (t *tapeImporter) command(at int, line string) {
+	// Sections and notes survive as comments, see ExportTape.
+	if strings.HasPrefix(line, "# ") {
+		op, err := parseLine(line[2:])
+		switch op.(type) {
+		case *OpSection, *OpNote:
+			if err == nil {
+				t.flush()
+				t.run = nil
+				*opPos(op) = Pos{Line: at}
+				t.script = append(t.script, op)
+				return
+			}
+		}
+	}
+
+	if ignoreLine(line) {
+		return
+	}
+
+	fields, err := tapeFields(line)
+	if err != nil {
+		t.warn(at, "%s", err)
+		return
+	}
+
+	name, speed, _ := strings.Cut(fields[0], "@")
+	args := fields[1:]
+	if speed != "" {
+		t.warn(at, "the typing speed of a single command is not supported.")
+	}
+
+	switch name {
+	case "Type":
+		t.send(at, strings.Join(args, " "))
+
+	case "Sleep":
+		if len(args) != 1 {
+			t.warn(at, "Sleep needs a duration.")
+			return
+		}
+		d, err := tapeDuration(args[0])
+		if err != nil {
+			t.warn(at, "%s", err)
+			return
+		}
+		if d == breathPause {
+			d = 0
+		}
+		// Tapes often Sleep for the program to start before typing into
+		// it, a RUN makes this pause of its own.
+		if t.run != nil && t.input == "" && d == subOpsPause {
+			return
+		}
+		if !t.hidden {
+			t.add(at, &OpBreath{nl: t.run == nil, d: d})
+		}
+
+	case "Hide":
+		t.hidden = true
+	case "Show":
+		t.hidden = false
+
+	case "Set":
+		if len(args) == 2 && args[0] == "Shell" && args[1] == "bash" {
+			return
+		}
+		if len(args) != 2 || args[0] != "TypingSpeed" {
+			t.warn(at, "%s is not supported.", line)
+			return
+		}
+		d, err := tapeDuration(args[1])
+		if err != nil {
+			t.warn(at, "%s", err)
+			return
+		}
+		// The rate applies to the script from here on, not to a program.
+		rate := max(1, int(math.Round(float64(time.Second)/float64(d))))
+		if rate == t.rate {
+			return
+		}
+		t.rate = rate
+		t.flush()
+		t.script = append(t.script, &OpRate{Pos: Pos{Line: at}, rate: rate})
+
+	case "Screenshot":
+		snapshot := ""
+		if len(args) == 1 {
+			snapshot = strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+		}
+		op, err := parseSnapshot(snapshot)
+		if err != nil {
+			t.warn(at, "%s", err)
+			return
+		}
+		t.add(at, op)
+
+	case "Wait":
+		// RUNs always wait for their command.
+
+	case "Output":
+		// Where the tape is rendered to.
+
+	case "Require", "Env", "Source", "Copy", "Paste":
+		t.warn(at, "%s is not supported.", name)
+
+	default:
+		seq, ok := tapeKey(name)
+		if !ok {
+			t.warn(at, "unknown command %s.", name)
+			return
+		}
+		n := 1
+		if len(args) > 0 {
+			n, err = strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				t.warn(at, "invalid count %q.", args[0])
+				return
+			}
+		}
+		t.send(at, strings.Repeat(seq, n))
+	}
+}
+
+// tapeType returns the Types which type s. A string can not contain its own
+// quote, so s is split where it would contain all three.
+func tapeType(s string) []string {
+	var lines []string
+	typ := func(t string) {
+		q := tapeQuote(t)
+		lines = append(lines, "Type "+q+t+q)
+	}
+
+	start := 0
+	for i := range len(s) {
+		if strings.IndexByte("\"'`", s[i]) >= 0 && tapeQuote(s[start:i+1]) == "" {
+			typ(s[start:i])
+			start = i
+		}
+	}
+	typ(s[start:])
+	return lines
+}
+
+// tapeQuote returns a quote which s does not contain, or "" when it contains
+// them all.
+func tapeQuote(s string) string {
+	for _, q := range []string{`"`, `'`, "`"} {
+		if !strings.Contains(s, q) {
+			return q
+		}
+	}
+	return ""
+}
+
+// tapeInput returns the tape commands which type s.
+func tapeInput(s string) []string {
+	var (
+		lines []string
+		text  string
+	)
+
+	press := func(key string) {
+		if text != "" {
+			lines = append(lines, tapeType(text)...)
+			text = ""
+		}
+		if n := len(lines); n > 0 {
+			last := lines[n-1]
+			if last == key {
+				lines[n-1] = key + " 2"
+				return
+			}
+			if k, count, ok := strings.Cut(last, " "); ok && k == key {
+				if c, err := strconv.Atoi(count); err == nil {
+					lines[n-1] = key + " " + strconv.Itoa(c+1)
+					return
+				}
+			}
+		}
+		lines = append(lines, key)
+	}
+
+keys:
+	for s != "" {
+		for _, name := range []string{"Up", "Down", "Right", "Left", "Home", "End", "Insert", "Delete", "PageUp", "PageDown"} {
+			if strings.HasPrefix(s, tapeKeys[name]) {
+				press(name)
+				s = s[len(tapeKeys[name]):]
+				continue keys
+			}
+		}
+
+		r, n := utf8.DecodeRuneInString(s)
+		s = s[n:]
+
+		switch {
+		case r == '\n' || r == '\r':
+			press("Enter")
+		case r == '\t':
+			press("Tab")
+		case r == '\b' || r == 0x7F:
+			press("Backspace")
+		case r == '\x1B':
+			press("Escape")
+		case r < ' ':
+			press("Ctrl+" + string(r+'@'))
+		default:
+			text += string(r)
+		}
+	}
+
+	if text != "" {
+		lines = append(lines, tapeType(text)...)
+	}
+	return lines
+}
+
+// ExportTape converts a script into a VHS tape which renders to output.
+// Everything the tape can not express is reported.
+func ExportTape(script Script, output string) (string, []Warning) {
+	var (
+		lines    = []string{"Output " + output, `Set Shell "bash"`, "Set TypingSpeed " + (time.Second / typingRate).String(), ""}
+		warnings []Warning
+		hidden   bool
+	)
+
+	warn := func(op Op, format string, args ...any) {
+		warnings = append(warnings, Warning{Pos: *opPos(op), Msg: fmt.Sprintf(format, args...)})
+	}
+
+	sleep := func(d time.Duration) {
+		if d == 0 {
+			d = breathPause
+		}
+		lines = append(lines, "Sleep "+d.String())
+	}
+
+	speed := func(rate int) {
+		lines = append(lines, "Set TypingSpeed "+(time.Second/time.Duration(rate)).String())
+	}
+
+	// marked types s with its marks. The rate of a mark lasts until the end
+	// of s, the same as in shellTyper.
+	rate := typingRate
+	marked := func(s string, marks []typingMark, typ func(string) []string) {
+		at, changed := 0, false
+		for _, m := range marks {
+			if m.at > at {
+				lines = append(lines, typ(s[at:m.at])...)
+				at = m.at
+			}
+			if m.pause > 0 {
+				lines = append(lines, "Sleep "+m.pause.String())
+			}
+			if m.rate != 0 {
+				speed(m.rate)
+				changed = true
+			}
+		}
+		if at < len(s) {
+			lines = append(lines, typ(s[at:])...)
+		}
+		if changed {
+			speed(rate)
+		}
+	}
+
+	for _, op := range script {
+		// Only SETUPs are hidden, comments and the typing speed do not show.
+		setup := hidden
+		switch x := op.(type) {
+		case *OpExec:
+			setup = x.setup
+		case *OpSection, *OpNote, *OpRate:
+		default:
+			setup = false
+		}
+		if setup != hidden {
+			hidden = setup
+			if hidden {
+				lines = append(lines, "Hide")
+			} else {
+				lines = append(lines, "Show")
+			}
+		}
+
+		switch x := op.(type) {
+		case *OpSection:
+			if lines[len(lines)-1] != "" {
+				lines = append(lines, "")
+			}
+			lines = append(lines, "# "+describe(op))
+		case *OpNote:
+			lines = append(lines, "# "+describe(op))
+		case *OpEcho:
+			lines = append(lines, tapeType("# "+x.content)...)
+			lines = append(lines, "Enter")
+		case *OpBreath:
+			sleep(x.d)
+		case *OpRate:
+			rate = x.rate
+			speed(rate)
+		case *OpSnapshot:
+			lines = append(lines, "Screenshot "+x.name+".png")
+		case *OpExec:
+			marked(x.cmd, x.marks, tapeType)
+			lines = append(lines, "Enter")
+			if len(x.Ops) > 0 {
+				sleep(subOpsPause)
+			}
+			for _, sub := range x.Ops {
+				switch y := sub.(type) {
+				case *OpType:
+					marked(y.content, y.marks, tapeInput)
+				case *OpBreath:
+					sleep(y.d)
+				case *OpSnapshot:
+					lines = append(lines, "Screenshot "+y.name+".png")
+				}
+			}
+			lines = append(lines, "Wait")
+
+			if x.expect != 0 {
+				warn(op, "EXPECT is not supported by VHS.")
+			}
+			if len(x.checks) > 0 {
+				warn(op, "OUTPUT checks are not supported by VHS.")
+			}
+		default:
+			warn(op, "%s is not supported by VHS.", describe(op))
+		}
+	}
+
+	if hidden {
+		lines = append(lines, "Show")
+	}
+	return strings.Join(lines, "\n") + "\n", warnings
+}
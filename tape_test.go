@@ -0,0 +1,196 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestImportTape(t *testing.T) {
+	tape := `Output demo.gif
+Set FontSize 32
+Set TypingSpeed 125ms
+
+Hide
+Type "cd /tmp"
+Enter
+Show
+Type "# hello"
+Enter
+Type "ech"
+Backspace 2
+Type 'cho "hi"'
+Enter
+Sleep 2s
+Type "vim x"
+Enter
+Type "ihi"
+Escape
+Sleep 500ms
+Ctrl+W
+Up 2
+Escape
+Type ":wq"
+Enter
+Type "less x"
+Enter
+Type "/query"
+Enter
+Type "q"
+Type "ls"
+Tab
+Type@10ms "x"
+Screenshot shots/end.png
+Enter
+Wait
+Dance
+Type "unfinished"
+`
+
+	script, warnings := ImportTape(tape)
+	got := Format(script)
+	want := `RATE 8
+SETUP cd /tmp
+SAY hello
+RUN echo "hi"
+BREATH 2s
+RUN vim x
+- TYPE ihi\e
+- BREATH 500ms
+- TYPE \ETB\e[A\e[A\e:wq\n
+RUN less x
+- TYPE /query\nq
+SNAPSHOT end
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	var msgs []string
+	for _, w := range warnings {
+		msgs = append(msgs, w.Msg)
+	}
+	wantMsgs := []string{
+		"Set FontSize 32 is not supported.",
+		"<HT> at the prompt is not supported.",
+		"the typing speed of a single command is not supported.",
+		`"lsx" is dropped, it was edited with keys which are not supported.`,
+		"unknown command Dance.",
+		`"unfinished" is typed, but never entered.`,
+	}
+	if !reflect.DeepEqual(msgs, wantMsgs) {
+		t.Errorf("got %q, want %q", msgs, wantMsgs)
+	}
+	if warnings[0].Line != 2 || warnings[3].Line != 35 || warnings[5].Line != 38 {
+		t.Errorf("unexpected positions %+v", warnings)
+	}
+}
+
+func TestExportTape(t *testing.T) {
+	script, err := Parse(`SECTION Intro
+SETUP cd /tmp
+SAY it's "quoted"
+RATE 20
+RUN vim
+- TYPE ihi\e:x\n\n\n
+- BREATH 2s
+- TYPE \ETX\e[B
+- EXPECT 1
+NOTE bye
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, warnings := ExportTape(script, "demo.gif")
+	want := "Output demo.gif\n" +
+		"Set Shell \"bash\"\n" +
+		"Set TypingSpeed 62.5ms\n" +
+		"\n" +
+		"# SECTION Intro\n" +
+		"Hide\n" +
+		"Type \"cd /tmp\"\n" +
+		"Enter\n" +
+		"Wait\n" +
+		"Show\n" +
+		"Type `# it's \"quoted\"`\n" +
+		"Enter\n" +
+		"Set TypingSpeed 50ms\n" +
+		"Type \"vim\"\n" +
+		"Enter\n" +
+		"Sleep 500ms\n" +
+		"Type \"ihi\"\n" +
+		"Escape\n" +
+		"Type \":x\"\n" +
+		"Enter 3\n" +
+		"Sleep 2s\n" +
+		"Ctrl+C\n" +
+		"Down\n" +
+		"Wait\n" +
+		"# NOTE bye\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if len(warnings) != 1 || warnings[0].Line != 5 || warnings[0].Msg != "EXPECT is not supported by VHS." {
+		t.Errorf("unexpected warnings %+v", warnings)
+	}
+}
+
+func TestExportTapeMarks(t *testing.T) {
+	script, err := Parse("RUN git commit -m {500ms}\"it's `done`\"\n- TYPE :wq{rate=4}\\n\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, warnings := ExportTape(script, "demo.gif")
+	want := "Output demo.gif\n" +
+		"Set Shell \"bash\"\n" +
+		"Set TypingSpeed 62.5ms\n" +
+		"\n" +
+		"Type \"git commit -m \"\n" +
+		"Sleep 500ms\n" +
+		"Type `\"it's `\n" +
+		"Type '`done`\"'\n" +
+		"Enter\n" +
+		"Sleep 500ms\n" +
+		"Type \":wq\"\n" +
+		"Set TypingSpeed 250ms\n" +
+		"Enter\n" +
+		"Set TypingSpeed 62.5ms\n" +
+		"Wait\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("unexpected warnings %+v", warnings)
+	}
+}
+
+func TestTapeRoundTrip(t *testing.T) {
+	data, err := os.ReadFile("example.termp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Parse(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tape, _ := ExportTape(want, "example.gif")
+	got, warnings := ImportTape(tape)
+	if len(warnings) != 0 {
+		t.Errorf("unexpected warnings %+v", warnings)
+	}
+
+	// VHS has no EXPECT and NARRATION.
+	for _, op := range want {
+		if x, ok := op.(*OpExec); ok {
+			x.expect, x.narration = 0, false
+		}
+	}
+	clearPos(want)
+	clearPos(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", Format(got), Format(want))
+	}
+}
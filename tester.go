@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// TestSuite holds the outcome of running a single script as a test.
+type TestSuite struct {
+	Name     string
+	Results  []*RunResult
+	Skipped  []string
+	Err      error
+	Duration time.Duration
+}
+
+func (t *TestSuite) Failed() bool {
+	if t.Err != nil {
+		return true
+	}
+	for _, r := range t.Results {
+		if r.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// RunTest runs the script name headlessly with opts.
+func RunTest(ctx context.Context, name string, opts Options) *TestSuite {
+	var (
+		suite = &TestSuite{Name: name}
+		start = time.Now()
+	)
+
+	script, err := ParseFile(name)
+	if err != nil {
+		suite.Err = err
+		return suite
+	}
+
+	opts.Headless = true
+	opts.OnRun = func(r *RunResult) {
+		suite.Results = append(suite.Results, r)
+	}
+	err = Exec(ctx, io.Discard, script, opts)
+	suite.Duration = time.Since(start)
+
+	var runs []string
+	for _, op := range script {
+		if x, ok := op.(*OpExec); ok {
+			runs = append(runs, x.cmd)
+		}
+	}
+	if len(runs) > len(suite.Results) {
+		suite.Skipped = runs[len(suite.Results):]
+	}
+
+	// Failures of a RUN are already part of its result.
+	if err != nil && (len(suite.Results) == 0 || suite.Results[len(suite.Results)-1].Err != err) {
+		suite.Err = err
+	}
+
+	return suite
+}
+
+func (t *TestSuite) Report(w io.Writer) {
+	fmt.Fprintf(w, "=== %s\n", t.Name)
+
+	var passed, failed int
+	for _, r := range t.Results {
+		if r.Err == nil {
+			passed++
+			fmt.Fprintf(w, "ok    RUN %s (%s)\n", r.Cmd, r.Duration.Round(time.Millisecond))
+			continue
+		}
+
+		failed++
+		fmt.Fprintf(w, "FAIL  RUN %s (%s)\n", r.Cmd, r.Duration.Round(time.Millisecond))
+		fmt.Fprintf(w, "      %s\n", r.Err)
+		for _, line := range strings.Split(strings.TrimRight(r.Output, "\n"), "\n") {
+			fmt.Fprintf(w, "      | %s\n", line)
+		}
+	}
+
+	for _, cmd := range t.Skipped {
+		fmt.Fprintf(w, "SKIP  RUN %s\n", cmd)
+	}
+
+	if t.Err != nil {
+		failed++
+		msg := strings.TrimRight(t.Err.Error(), "\n")
+		fmt.Fprintf(w, "FAIL  %s\n", strings.ReplaceAll(msg, "\n", "\n      "))
+	}
+
+	status := "PASS"
+	if t.Failed() {
+		status = "FAIL"
+	}
+
+	fmt.Fprintf(w, "--- %s %s (%d passed, %d failed, %d skipped, %s)\n",
+		status, t.Name, passed, failed, len(t.Skipped), t.Duration.Round(time.Millisecond))
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func WriteJUnit(w io.Writer, suites []*TestSuite) error {
+	var doc junitSuites
+
+	for _, t := range suites {
+		js := junitSuite{Name: t.Name, Time: t.Duration.Seconds()}
+
+		for _, r := range t.Results {
+			jc := junitCase{
+				Name:      "RUN " + r.Cmd,
+				ClassName: t.Name,
+				Time:      r.Duration.Seconds(),
+				SystemOut: r.Output,
+			}
+			if r.Err != nil {
+				jc.Failure = &junitFailure{Message: r.Err.Error(), Body: r.Output}
+				js.Failures++
+			}
+			js.Cases = append(js.Cases, jc)
+		}
+
+		for _, cmd := range t.Skipped {
+			js.Cases = append(js.Cases, junitCase{
+				Name:      "RUN " + cmd,
+				ClassName: t.Name,
+				Skipped:   &struct{}{},
+			})
+			js.Skipped++
+		}
+
+		if t.Err != nil {
+			js.Cases = append(js.Cases, junitCase{
+				Name:      "script",
+				ClassName: t.Name,
+				Failure:   &junitFailure{Message: t.Err.Error()},
+			})
+			js.Failures++
+		}
+
+		js.Tests = len(js.Cases)
+		doc.Suites = append(doc.Suites, js)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(doc)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func runTests(ctx context.Context, srcs []string, junit string, snaps *Snapshots) {
+	var (
+		suites []*TestSuite
+		failed bool
+	)
+
+	for _, src := range srcs {
+		if ctx.Err() != nil {
+			break
+		}
+
+		t := RunTest(ctx, src, Options{Snapshots: snaps.For(src)})
+		t.Report(os.Stdout)
+		suites = append(suites, t)
+		failed = failed || t.Failed()
+	}
+
+	if junit != "" {
+		f, err := os.Create(junit)
+		if err == nil {
+			err = WriteJUnit(f, suites)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if ctx.Err() != nil {
+		os.Exit(130)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
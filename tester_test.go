@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runTestSource runs source as a test against a fake shell.
+func runTestSource(t *testing.T, source string) *TestSuite {
+	t.Helper()
+
+	name := filepath.Join(t.TempDir(), "demo.termp")
+	err := os.WriteFile(name, []byte(source), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return RunTest(context.Background(), name, Options{
+		Shell: &fakeShell{pty: newFakePty(echoRun)},
+		Clock: newFakeClock(),
+	})
+}
+
+func TestRunTestPass(t *testing.T) {
+	suite := runTestSource(t, "RUN echo hi\nRUN exit 2\n- EXPECT 2\n")
+
+	if suite.Failed() || len(suite.Results) != 2 || len(suite.Skipped) != 0 {
+		t.Fatalf("unexpected suite %+v", suite)
+	}
+	if r := suite.Results[0]; r.Cmd != "echo hi" || r.Output != "hi\n" {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	var out bytes.Buffer
+	suite.Report(&out)
+	for _, want := range []string{"ok    RUN echo hi", "ok    RUN exit 2", "--- PASS", "(2 passed, 0 failed, 0 skipped"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRunTestFail(t *testing.T) {
+	tests := []struct {
+		source, err string
+	}{
+		{"RUN echo a\nRUN nope\nRUN echo b\nRUN echo c", "exited with status 127."},
+		{"RUN echo a\nRUN exit 3\n- EXPECT 2\nRUN echo b\nRUN echo c", "exited with status 3 (expected 2)."},
+		{"RUN echo a\nRUN echo ok\n- EXPECT 1\nRUN echo b\nRUN echo c", "exited with status 0 (expected 1)."},
+	}
+
+	for _, test := range tests {
+		suite := runTestSource(t, test.source)
+
+		if !suite.Failed() || suite.Err != nil || len(suite.Results) != 2 {
+			t.Fatalf("%q: unexpected suite %+v", test.source, suite)
+		}
+		if err := suite.Results[1].Err; err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: got %v, want %q", test.source, err, test.err)
+		}
+		if want := []string{"echo b", "echo c"}; strings.Join(suite.Skipped, ",") != strings.Join(want, ",") {
+			t.Errorf("%q: skipped %q, want %q", test.source, suite.Skipped, want)
+		}
+
+		var out bytes.Buffer
+		suite.Report(&out)
+		for _, want := range []string{"FAIL  RUN ", "SKIP  RUN echo b", "--- FAIL", "(1 passed, 1 failed, 2 skipped"} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("report does not contain %q:\n%s", want, out.String())
+			}
+		}
+	}
+}
+
+func TestRunTestParseError(t *testing.T) {
+	suite := runTestSource(t, "RUN echo a\nBOGUS\n")
+
+	if !suite.Failed() || suite.Err == nil || len(suite.Results) != 0 {
+		t.Fatalf("unexpected suite %+v", suite)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	suites := []*TestSuite{
+		runTestSource(t, "RUN echo hi\nRUN nope\nRUN echo skipped"),
+		runTestSource(t, "BOGUS"),
+	}
+
+	var out bytes.Buffer
+	err := WriteJUnit(&out, suites)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), xml.Header+"<testsuites>") {
+		t.Errorf("unexpected start of %q", out.String())
+	}
+
+	var doc junitSuites
+	err = xml.Unmarshal(out.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Suites) != 2 {
+		t.Fatalf("got %d suites, want 2", len(doc.Suites))
+	}
+
+	js := doc.Suites[0]
+	if js.Tests != 3 || js.Failures != 1 || js.Skipped != 1 || len(js.Cases) != 3 {
+		t.Errorf("unexpected suite %+v", js)
+	}
+	if c := js.Cases[0]; c.Name != "RUN echo hi" || c.ClassName != suites[0].Name || c.SystemOut != "hi\n" || c.Failure != nil {
+		t.Errorf("unexpected passing case %+v", c)
+	}
+	if c := js.Cases[1]; c.Failure == nil || !strings.Contains(c.Failure.Message, "status 127") {
+		t.Errorf("unexpected failing case %+v", c)
+	}
+	if c := js.Cases[2]; c.Name != "RUN echo skipped" || c.Skipped == nil {
+		t.Errorf("unexpected skipped case %+v", c)
+	}
+
+	js = doc.Suites[1]
+	if js.Tests != 1 || js.Failures != 1 || js.Cases[0].Name != "script" || js.Cases[0].Failure == nil {
+		t.Errorf("unexpected suite of a parse error %+v", js)
+	}
+}
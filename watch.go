@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// watchInterval is how often the files of a watched script are checked.
+const watchInterval = 250 * time.Millisecond
+
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+func stampFiles(files []string) map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	for _, name := range files {
+		info, err := os.Stat(name)
+		if err == nil {
+			stamps[name] = fileStamp{info.ModTime(), info.Size()}
+		} else {
+			stamps[name] = fileStamp{}
+		}
+	}
+	return stamps
+}
+
+func changed(files []string, stamps map[string]fileStamp) bool {
+	for name, stamp := range stampFiles(files) {
+		if stamps[name] != stamp {
+			return true
+		}
+	}
+	return false
+}
+
+// opKey describes everything about op which affects how it runs, but not
+// where it is in the script.
+func opKey(op Op) string {
+	key := describe(op)
+
+	x, ok := op.(*OpExec)
+	if !ok {
+		return key
+	}
+
+	key += fmt.Sprintf(" expect=%d narration=%t", x.expect, x.narration)
+	for _, c := range x.checks {
+		switch c := c.(type) {
+		case *OutputContains:
+			key += fmt.Sprintf(" contains=%q", c.text)
+		case *OutputMatches:
+			key += fmt.Sprintf(" matches=%q", c.pattern)
+		}
+	}
+	for _, sub := range x.Ops {
+		key += "\n- " + opKey(sub)
+	}
+	return key
+}
+
+// changedSection returns the index of the first op of the section of the
+// first op which is different in script compared to prev. It returns false
+// when no op changed.
+func changedSection(prev, script Script) (int, bool) {
+	i := 0
+	for i < len(prev) && i < len(script) && opKey(prev[i]) == opKey(script[i]) {
+		i++
+	}
+
+	switch {
+	case i == len(prev) && i == len(script):
+		return 0, false
+	case i == len(script):
+		i--
+	}
+
+	if i < 0 {
+		return 0, true
+	}
+
+	// The section is found by where it is rather than by its title, which
+	// may be used twice or look like a line number.
+	section := opPos(script[i]).Section
+	for i > 0 && opPos(script[i-1]).Section == section {
+		if _, ok := script[i].(*OpSection); ok {
+			break
+		}
+		i--
+	}
+	return i, true
+}
+
+// Watcher re-parses a script whenever it or one of the files it includes
+// changes and reports parse errors right away. With Run set the script is
+// also executed, starting at the section containing the first change.
+type Watcher struct {
+	Name      string
+	Run       bool
+	Fast      bool
+	Snapshots *Snapshots
+
+	prev   Script
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (w *Watcher) Watch(ctx context.Context) {
+	defer w.stop()
+
+	for {
+		script, files, err := parseFiles(w.Name)
+		stamps := stampFiles(files)
+
+		if err != nil {
+			fmt.Printf("\x1B[0m\x1B[31m! %s\x1B[0m\n", err)
+		} else {
+			w.update(ctx, script)
+		}
+
+		for !changed(files, stamps) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(watchInterval):
+			}
+		}
+	}
+}
+
+// update runs script when it changed since the last time.
+func (w *Watcher) update(ctx context.Context, script Script) {
+	start, ok := changedSection(w.prev, script)
+	first := w.prev == nil
+	w.prev = script
+
+	if !w.Run {
+		fmt.Printf("\x1B[0m\x1B[32mok\x1B[0m %s (%d ops)\n", w.Name, len(script))
+		return
+	}
+
+	if !ok && !first {
+		fmt.Printf("\x1B[0m\x1B[2m%s changed, but none of its ops did.\x1B[0m\n", w.Name)
+		return
+	}
+
+	w.stop()
+
+	if first {
+		start = 0
+	}
+	part := script.slice(start, len(script)-1)
+
+	fmt.Print("\x1B[0m\x1B[H\x1B[2J")
+	if start > 0 {
+		fmt.Printf("\x1B[2m%s changed, running from section %s.\x1B[0m\n", w.Name, opPos(script[start]).Section)
+	}
+
+	opts := Options{Snapshots: w.Snapshots}
+	if w.Fast {
+		opts.Clock = fastClock{}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	w.cancel, w.done = cancel, make(chan struct{})
+
+	go func(done chan struct{}) {
+		defer close(done)
+		Exec(runCtx, os.Stdout, part, opts)
+	}(w.done)
+}
+
+// stop interrupts the running script and waits for it.
+func (w *Watcher) stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+	w.cancel, w.done = nil, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChangedSection(t *testing.T) {
+	const base = "SAY a\nSECTION One\nRUN ls\n- EXPECT 1\nSECTION Two\nSAY b\n"
+
+	tests := []struct {
+		name    string
+		source  string
+		start   int
+		changed bool
+	}{
+		{"same", base, 0, false},
+		{"comments and blank lines", "# x\n\nSAY a\nSECTION One\nRUN ls\n\n- EXPECT 1\nSECTION Two\nSAY b\n", 0, false},
+		{"before any section", "SAY A\nSECTION One\nRUN ls\n- EXPECT 1\nSECTION Two\nSAY b\n", 0, true},
+		{"sub op", "SAY a\nSECTION One\nRUN ls\n- EXPECT 2\nSECTION Two\nSAY b\n", 1, true},
+		{"appended", base + "SAY c\n", 3, true},
+		{"removed", "SAY a\nSECTION One\nRUN ls\n- EXPECT 1\n", 1, true},
+		{"renamed section", "SAY a\nSECTION One\nRUN ls\n- EXPECT 1\nSECTION 2\nSAY b\n", 3, true},
+		{"numeric title", "SAY a\nSECTION One\nRUN ls\n- EXPECT 1\nSECTION 2\nSAY c\n", 3, true},
+		{"duplicate title", "SAY a\nSECTION One\nRUN ls\n- EXPECT 1\nSECTION One\nSAY c\n", 3, true},
+	}
+
+	prev, err := Parse(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := Parse(test.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start, changed := changedSection(prev, script)
+			if start != test.start || changed != test.changed {
+				t.Errorf("got %d %t, want %d %t", start, changed, test.start, test.changed)
+			}
+		})
+	}
+}
+
+func TestFileStamps(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		name = filepath.Join(dir, "a.termp")
+		gone = filepath.Join(dir, "missing.termp")
+	)
+
+	err := os.WriteFile(name, []byte("SAY a\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{name, gone}
+	stamps := stampFiles(files)
+	if changed(files, stamps) {
+		t.Errorf("nothing changed yet")
+	}
+
+	err = os.WriteFile(gone, []byte("SAY b\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed(files, stamps) {
+		t.Errorf("expected a created file to be a change")
+	}
+
+	stamps = stampFiles(files)
+	os.Chtimes(name, time.Now(), time.Now().Add(time.Hour))
+	if !changed(files, stamps) {
+		t.Errorf("expected a new modification time to be a change")
+	}
+}
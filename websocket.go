@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Just enough of RFC 6455 to push text messages to browsers.
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes.
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// wsMaxPayload bounds the size of messages read from clients.
+const wsMaxPayload = 1 << 16
+
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// wsUpgrade completes the opening handshake and takes over the connection.
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.Reader, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "expected a websocket handshake.", http.StatusBadRequest)
+		return nil, nil, errors.New("expected a websocket handshake.")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websockets are not supported.", http.StatusInternalServerError)
+		return nil, nil, errors.New("websockets are not supported.")
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: "+wsAccept(key)+"\r\n\r\n")
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, rw.Reader, nil
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, f := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(f), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsWriteFrame writes a single unfragmented frame. Clients must mask their
+// frames, servers must not.
+func wsWriteFrame(w io.Writer, op byte, payload []byte, mask []byte) error {
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | op
+
+	n := len(payload)
+	switch {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	if mask != nil {
+		header[1] |= 0x80
+		header = append(header, mask[:4]...)
+		masked := make([]byte, n)
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+// wsReadFrame reads a single frame and unmasks its payload.
+func wsReadFrame(r io.Reader) (byte, []byte, error) {
+	var header [2]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	op := header[0] & 0x0F
+	n := uint64(header[1] & 0x7F)
+
+	switch n {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(r, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(r, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if n > wsMaxPayload {
+		return 0, nil, errors.New("websocket message too large.")
+	}
+
+	var mask [4]byte
+	masked := header[1]&0x80 != 0
+	if masked {
+		_, err = io.ReadFull(r, mask[:])
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	payload := make([]byte, n)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return op, payload, nil
+}